@@ -0,0 +1,264 @@
+package art
+
+// state shared by every kind of node
+// a node stands for the path made of the bytes above it,
+// the byte it is branched on, and its own prefix
+type header struct {
+	// compressed path between the branching byte and this node
+	prefix []byte
+	value  int
+	// pending increment of the selector of this path, e.g. "home/*"
+	selector    int
+	hasSelector bool
+	endOfKey    bool
+}
+
+// node of the adaptive radix tree
+// the kinds only differ in how they store their children,
+// a full node is replaced by a larger kind when a child is added
+type node interface {
+	base() *header
+	// returns the slot of the child branched on "b", or nil if there is none
+	childRef(b byte) *node
+	// adds a child, the node must not be full
+	addChild(b byte, child node)
+	isFull() bool
+	// returns a node of the next larger kind with the same content
+	grow() node
+	numChildren() int
+	// calls "visit" for every child, ordered by branching byte
+	forEachChild(visit func(b byte, child node))
+	// appends the children to "dst", in no particular order
+	appendChildren(dst []node) []node
+}
+
+// node without children
+type leaf struct {
+	header
+}
+
+// node with up to 4 children, branching bytes kept sorted
+type node4 struct {
+	header
+	keys     [4]byte
+	children [4]node
+	size     uint8
+}
+
+// node with up to 16 children, branching bytes kept sorted
+type node16 struct {
+	header
+	keys     [16]byte
+	children [16]node
+	size     uint8
+}
+
+// node with up to 48 children, indexed by a 256-way table of slots
+type node48 struct {
+	header
+	// slot of the child for every byte, plus one, zero means no child
+	index    [256]uint8
+	children [48]node
+	size     uint8
+}
+
+// node with up to 256 children, indexed directly by byte
+type node256 struct {
+	header
+	children [256]node
+	size     uint16
+}
+
+var (
+	_ node = (*leaf)(nil)
+	_ node = (*node4)(nil)
+	_ node = (*node16)(nil)
+	_ node = (*node48)(nil)
+	_ node = (*node256)(nil)
+)
+
+func (n *leaf) base() *header                               { return &n.header }
+func (n *leaf) childRef(b byte) *node                       { return nil }
+func (n *leaf) addChild(b byte, child node)                 { panic("art: leaf cannot hold children") }
+func (n *leaf) isFull() bool                                { return true }
+func (n *leaf) numChildren() int                            { return 0 }
+func (n *leaf) forEachChild(visit func(b byte, child node)) {}
+func (n *leaf) appendChildren(dst []node) []node            { return dst }
+
+func (n *leaf) grow() node {
+	return &node4{header: n.header}
+}
+
+// returns the position of "b" in the sorted "keys",
+// or the position it would be inserted at
+func searchKeys(keys []byte, b byte) int {
+	idx := 0
+	for idx < len(keys) && keys[idx] < b {
+		idx++
+	}
+	return idx
+}
+
+func (n *node4) base() *header    { return &n.header }
+func (n *node4) isFull() bool     { return n.size == 4 }
+func (n *node4) numChildren() int { return int(n.size) }
+
+func (n *node4) childRef(b byte) *node {
+	for idx := 0; idx < int(n.size); idx++ {
+		if n.keys[idx] == b {
+			return &n.children[idx]
+		}
+	}
+	return nil
+}
+
+func (n *node4) addChild(b byte, child node) {
+	idx := searchKeys(n.keys[:n.size], b)
+	copy(n.keys[idx+1:], n.keys[idx:n.size])
+	copy(n.children[idx+1:], n.children[idx:n.size])
+	n.keys[idx] = b
+	n.children[idx] = child
+	n.size++
+}
+
+func (n *node4) grow() node {
+	grown := &node16{header: n.header, size: n.size}
+	copy(grown.keys[:], n.keys[:n.size])
+	copy(grown.children[:], n.children[:n.size])
+	return grown
+}
+
+func (n *node4) forEachChild(visit func(b byte, child node)) {
+	for idx := 0; idx < int(n.size); idx++ {
+		visit(n.keys[idx], n.children[idx])
+	}
+}
+
+func (n *node4) appendChildren(dst []node) []node {
+	return append(dst, n.children[:n.size]...)
+}
+
+func (n *node16) base() *header    { return &n.header }
+func (n *node16) isFull() bool     { return n.size == 16 }
+func (n *node16) numChildren() int { return int(n.size) }
+
+func (n *node16) childRef(b byte) *node {
+	// binary search over the sorted keys
+	low, high := 0, int(n.size)
+	for low < high {
+		middle := (low + high) / 2
+		if n.keys[middle] < b {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	if low < int(n.size) && n.keys[low] == b {
+		return &n.children[low]
+	}
+	return nil
+}
+
+func (n *node16) addChild(b byte, child node) {
+	idx := searchKeys(n.keys[:n.size], b)
+	copy(n.keys[idx+1:], n.keys[idx:n.size])
+	copy(n.children[idx+1:], n.children[idx:n.size])
+	n.keys[idx] = b
+	n.children[idx] = child
+	n.size++
+}
+
+func (n *node16) grow() node {
+	grown := &node48{header: n.header, size: n.size}
+	for idx := 0; idx < int(n.size); idx++ {
+		grown.index[n.keys[idx]] = uint8(idx + 1)
+		grown.children[idx] = n.children[idx]
+	}
+	return grown
+}
+
+func (n *node16) forEachChild(visit func(b byte, child node)) {
+	for idx := 0; idx < int(n.size); idx++ {
+		visit(n.keys[idx], n.children[idx])
+	}
+}
+
+func (n *node16) appendChildren(dst []node) []node {
+	return append(dst, n.children[:n.size]...)
+}
+
+func (n *node48) base() *header    { return &n.header }
+func (n *node48) isFull() bool     { return n.size == 48 }
+func (n *node48) numChildren() int { return int(n.size) }
+
+func (n *node48) childRef(b byte) *node {
+	if slot := n.index[b]; slot != 0 {
+		return &n.children[slot-1]
+	}
+	return nil
+}
+
+func (n *node48) addChild(b byte, child node) {
+	n.children[n.size] = child
+	n.size++
+	n.index[b] = n.size
+}
+
+func (n *node48) grow() node {
+	grown := &node256{header: n.header, size: uint16(n.size)}
+	for b, slot := range n.index {
+		if slot != 0 {
+			grown.children[b] = n.children[slot-1]
+		}
+	}
+	return grown
+}
+
+func (n *node48) forEachChild(visit func(b byte, child node)) {
+	for b, slot := range n.index {
+		if slot != 0 {
+			visit(byte(b), n.children[slot-1])
+		}
+	}
+}
+
+func (n *node48) appendChildren(dst []node) []node {
+	return append(dst, n.children[:n.size]...)
+}
+
+func (n *node256) base() *header    { return &n.header }
+func (n *node256) isFull() bool     { return false }
+func (n *node256) numChildren() int { return int(n.size) }
+
+func (n *node256) childRef(b byte) *node {
+	if n.children[b] != nil {
+		return &n.children[b]
+	}
+	return nil
+}
+
+func (n *node256) addChild(b byte, child node) {
+	n.children[b] = child
+	n.size++
+}
+
+func (n *node256) grow() node {
+	panic("art: node256 cannot grow")
+}
+
+func (n *node256) forEachChild(visit func(b byte, child node)) {
+	for b, child := range n.children {
+		if child != nil {
+			visit(byte(b), child)
+		}
+	}
+}
+
+func (n *node256) appendChildren(dst []node) []node {
+	for _, child := range n.children {
+		if child != nil {
+			dst = append(dst, child)
+		}
+	}
+	return dst
+}
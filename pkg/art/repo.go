@@ -0,0 +1,263 @@
+package art
+
+import (
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/key"
+	"sync"
+)
+
+// implements pkg.KeyValueRepository on an adaptive radix tree
+// with the same prefix selector semantics as trie.Repository
+type Repository struct {
+	rw        sync.RWMutex
+	root      node
+	size      int
+	converter key.Converter
+	validator key.Validator
+}
+
+func New() *Repository {
+	return &Repository{
+		size:      0,
+		root:      &node4{},
+		converter: key.NewConverterPipeline(),
+		validator: key.NewValidatorPipeline(),
+	}
+}
+
+var _ pkg.KeyValueRepository = (*Repository)(nil)
+
+func (t *Repository) AddConverter(converter key.Converter) *Repository {
+	t.converter = converter
+	return t
+}
+
+func (t *Repository) AddValidator(validator key.Validator) *Repository {
+	t.validator = validator
+	return t
+}
+
+// returns the path the entry walks along
+// a selector only walks until the byte before the selector
+func entryPath(entry *key.Key) []byte {
+	if entry.IsSelector() {
+		return []byte(*entry)[:entry.Size()-1]
+	}
+	return []byte(*entry)
+}
+
+func commonPrefixLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// walks the tree along "path", splitting prefixes, creating
+// and growing the missing nodes so that a node ends exactly at "path"
+// returns the header of the node at the end of "path"
+func (t *Repository) forceWalk(path []byte) *header {
+	ref := &t.root
+	depth := 0
+	for {
+		current := *ref
+		h := current.base()
+		shared := commonPrefixLength(h.prefix, path[depth:])
+		if shared < len(h.prefix) {
+			// the path leaves the prefix of current, which is split
+			// into a new parent holding the shared part
+			parent := &node4{header: header{prefix: h.prefix[:shared:shared]}}
+			branch := h.prefix[shared]
+			h.prefix = h.prefix[shared+1:]
+			parent.addChild(branch, current)
+			*ref = parent
+			current, h = parent, parent.base()
+		}
+		depth += shared
+		if depth == len(path) {
+			return h
+		}
+		branch := path[depth]
+		child := current.childRef(branch)
+		if child == nil {
+			created := &leaf{header: header{prefix: append([]byte(nil), path[depth+1:]...)}}
+			if current.isFull() {
+				current = current.grow()
+				*ref = current
+			}
+			current.addChild(branch, created)
+			return created.base()
+		}
+		ref = child
+		depth++
+	}
+}
+
+// walks the tree along "path" without creating nodes
+// returns the node "path" ends on, or the node whose prefix "path" ends in,
+// the part of that prefix that is beyond "path", empty if "path" ends on the node
+// and if the whole path has been walked or not
+func (t *Repository) lazyWalk(path []byte) (lastNode node, rest []byte, completeWalk bool) {
+	current := t.root
+	depth := 0
+	for {
+		h := current.base()
+		remaining := path[depth:]
+		shared := commonPrefixLength(h.prefix, remaining)
+		if shared == len(remaining) {
+			return current, h.prefix[shared:], true
+		}
+		if shared < len(h.prefix) {
+			return current, nil, false
+		}
+		depth += shared
+		child := current.childRef(path[depth])
+		if child == nil {
+			return current, nil, false
+		}
+		current = *child
+		depth++
+	}
+}
+
+func (t *Repository) Insert(pattern string, value int) error {
+	entry := key.New(pattern, t.converter, t.validator)
+	if entry.IsSelector() {
+		return key.NewErrSelectorKeyNotAllowed(*entry)
+	}
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	h := t.forceWalk(entryPath(entry))
+	if h.endOfKey {
+		return key.NewErrKeyAlreadyExist(*entry)
+	}
+	t.size++
+	h.endOfKey = true
+	h.value = value
+	return nil
+}
+
+// fills "out" with the keys below "n", "path" holds the bytes leading to "n"
+func dfsFillMap(n node, path []byte, out map[string]int) {
+	h := n.base()
+	if h.endOfKey {
+		out[string(path)] = h.value
+	}
+	if h.hasSelector {
+		out[string(append(path, key.SelectorChar))] = h.selector
+	}
+	n.forEachChild(func(b byte, child node) {
+		childPath := append(append(path, b), child.base().prefix...)
+		dfsFillMap(child, childPath, out)
+	})
+}
+
+func (t *Repository) GetMap(pattern string) map[string]int {
+	entry := key.New(pattern, t.converter, t.validator)
+	results := make(map[string]int)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	path := entryPath(entry)
+	n, rest, completeWalk := t.lazyWalk(path)
+	if !completeWalk {
+		return results
+	}
+	if entry.IsSelector() {
+		dfsFillMap(n, append(path, rest...), results)
+	} else if len(rest) == 0 && n.base().endOfKey {
+		results[string(*entry)] = n.base().value
+	}
+	return results
+}
+
+// sums the keys below "n", adding the selectors above every key
+// walks with an explicit stack, the tree can be wide and deep
+func sumValues(n node) int {
+	type frame struct {
+		n     node
+		carry int
+	}
+	var (
+		result   = 0
+		stack    = []frame{{n: n}}
+		children []node
+	)
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		h := top.n.base()
+		carry := top.carry + h.selector
+		if h.endOfKey {
+			result += h.value + carry
+		}
+		children = top.n.appendChildren(children[:0])
+		for _, child := range children {
+			stack = append(stack, frame{n: child, carry: carry})
+		}
+	}
+	return result
+}
+
+func (t *Repository) GetValue(pattern string) int {
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	n, rest, completeWalk := t.lazyWalk(entryPath(entry))
+	if !completeWalk {
+		return 0
+	}
+	if entry.IsSelector() {
+		return sumValues(n)
+	}
+	if len(rest) != 0 {
+		return 0
+	}
+	return n.base().selector + n.base().value
+}
+
+func (t *Repository) Inc(pattern string) error {
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	path := entryPath(entry)
+	n, rest, completeWalk := t.lazyWalk(path)
+	if !completeWalk {
+		return key.NewErrKeyNotFound(*entry)
+	}
+	if entry.IsSelector() {
+		h := t.forceWalk(path)
+		h.selector++
+		h.hasSelector = true
+		if !h.endOfKey {
+			return key.NewErrKeyNotFound(*entry)
+		}
+		return nil
+	}
+	if len(rest) != 0 || !n.base().endOfKey {
+		return key.NewErrKeyNotFound(*entry)
+	}
+	n.base().value++
+	return nil
+}
+
+func (t *Repository) Contains(pattern string) bool {
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	n, rest, completeWalk := t.lazyWalk(entryPath(entry))
+	if !completeWalk {
+		return false
+	}
+	h := n.base()
+	if len(rest) == 0 && h.endOfKey {
+		return true
+	}
+	return entry.IsSelector() && (len(rest) != 0 || n.numChildren() > 0 || h.hasSelector)
+}
+
+func (t *Repository) Size() int {
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	return t.size
+}
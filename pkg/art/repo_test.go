@@ -0,0 +1,66 @@
+package art
+
+import (
+	"fmt"
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/repotest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newSharedRepository() pkg.KeyValueRepository {
+	return New()
+}
+
+func TestRepository_Shared(t *testing.T) {
+	repotest.Run(t, newSharedRepository)
+}
+
+func BenchmarkRepository_Shared(b *testing.B) {
+	repotest.Benchmark(b, newSharedRepository)
+}
+
+func TestRepository_forceWalk_SplitsPrefix(t *testing.T) {
+	repo := New()
+	_ = repo.Insert("abcdef", 1)
+	_ = repo.Insert("abxy", 2)
+	child := *repo.root.childRef('a')
+	assert.Equal(t, "b", string(child.base().prefix))
+	assert.Equal(t, 2, child.numChildren())
+	assert.Equal(t, "def", string((*child.childRef('c')).base().prefix))
+	assert.Equal(t, "y", string((*child.childRef('x')).base().prefix))
+}
+
+func TestRepository_lazyWalk_InsidePrefix(t *testing.T) {
+	repo := New()
+	_ = repo.Insert("abcdef", 1)
+	n, rest, completeWalk := repo.lazyWalk([]byte("abc"))
+	assert.True(t, completeWalk)
+	assert.Equal(t, "def", string(rest))
+	// "abc" ends inside the prefix of the node holding "abcdef"
+	assert.True(t, n.base().endOfKey)
+	_, _, completeWalk = repo.lazyWalk([]byte("abx"))
+	assert.False(t, completeWalk)
+}
+
+func TestRepository_Insert_GrowsNodes(t *testing.T) {
+	repo := New()
+	kinds := map[int]string{4: "*art.node4", 16: "*art.node16", 48: "*art.node48", 256: "*art.node256"}
+	for i := 0; i < 256; i++ {
+		_ = repo.Insert(string([]byte{'k', byte(i), '.'}), i)
+		if kind, ok := kinds[i+1]; ok {
+			assert.Equal(t, kind, fmt.Sprintf("%T", *repo.root.childRef('k')))
+		}
+	}
+	assert.Equal(t, 256, repo.Size())
+	for i := 0; i < 256; i++ {
+		assert.Equal(t, i, repo.GetValue(string([]byte{'k', byte(i), '.'})))
+	}
+	visited := make([]byte, 0, 256)
+	(*repo.root.childRef('k')).forEachChild(func(b byte, child node) {
+		visited = append(visited, b)
+	})
+	for i, b := range visited {
+		assert.Equal(t, byte(i), b)
+	}
+}
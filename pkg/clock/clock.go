@@ -0,0 +1,57 @@
+// Package clock abstracts the current time, so code depending
+// on time can be tested deterministically
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// source of the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// returns the clock of the operating system
+func System() Clock {
+	return systemClock{}
+}
+
+// clock that only moves when it is told to, for tests
+// it is safe for concurrent use
+type Manual struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+var _ Clock = (*Manual)(nil)
+
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.now
+}
+
+func (m *Manual) Set(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.now = now
+}
+
+// moves the clock forward by "d" and returns the new time
+func (m *Manual) Advance(d time.Duration) time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.now = m.now.Add(d)
+	return m.now
+}
@@ -0,0 +1,23 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSystem_Now(t *testing.T) {
+	before := time.Now()
+	now := System().Now()
+	assert.False(t, now.Before(before))
+}
+
+func TestManual_Advance(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManual(start)
+	assert.Equal(t, start, clock.Now())
+	assert.Equal(t, start.Add(time.Minute), clock.Advance(time.Minute))
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
@@ -0,0 +1,23 @@
+package durable
+
+import "fmt"
+
+// error that is returned for every change once the log could not be
+// restored after a failed append, until a snapshot starts a new log
+type ErrLogFailed struct {
+	cause error
+}
+
+var _ error = (*ErrLogFailed)(nil)
+
+func NewErrLogFailed(cause error) *ErrLogFailed {
+	return &ErrLogFailed{cause: cause}
+}
+
+func (e *ErrLogFailed) Error() string {
+	return fmt.Sprintf("write-ahead log failed: %v", e.cause)
+}
+
+func (e *ErrLogFailed) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,422 @@
+package durable
+
+import (
+	"fmt"
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/trie"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// when the write-ahead log is flushed to stable storage
+type SyncPolicy int
+
+const (
+	// fsync after every record, nothing acknowledged is ever lost
+	SyncAlways SyncPolicy = iota
+	// fsync periodically, a crash loses at most one interval of calls
+	SyncInterval
+	// never fsync, leave flushing to the operating system
+	SyncNever
+)
+
+type Options struct {
+	Sync SyncPolicy
+	// period of the background fsync when Sync is SyncInterval
+	SyncInterval time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{Sync: SyncAlways, SyncInterval: time.Second}
+}
+
+const (
+	snapshotPrefix = "snapshot-"
+	logPrefix      = "wal-"
+	tempSuffix     = ".tmp"
+)
+
+// implements pkg.KeyValueRepository
+// every mutating call is appended to a write-ahead log
+// before being applied to the underlying trie repository
+//
+// the directory holds one generation of files at a time:
+// "snapshot-N" with the state at the start of the generation
+// and "wal-N" with the calls made since
+type Repository struct {
+	// serializes appending and applying, so the log order is the apply order
+	mu         sync.Mutex
+	repo       *trie.Repository
+	dir        string
+	generation uint64
+	log        *os.File
+	// size of the log up to the last complete record
+	offset  int64
+	options Options
+	// set when a failed append could not be undone, the log may then
+	// end with a torn record that would hide the records after it
+	failed error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+var _ pkg.KeyValueRepository = (*Repository)(nil)
+
+// opens the durable repository stored in "dir", creating it if needed
+// the latest snapshot is loaded into "repo" and the log is replayed on top of it
+// "repo" must be empty and carry the converter and validator used when writing
+func Open(dir string, repo *trie.Repository, options Options) (*Repository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	generation, err := latestGeneration(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadSnapshot(filepath.Join(dir, snapshotName(generation)), repo); err != nil {
+		return nil, err
+	}
+	logPath := filepath.Join(dir, logName(generation))
+	// the calls are replayed at the time they were made, so a decaying
+	// or time series repository counts them as it did then
+	original := repo.Clock()
+	replayClock := clock.NewManual(original.Now())
+	repo.AddClock(replayClock)
+	validSize, err := replayLog(logPath, func(rec record) {
+		if rec.at.IsZero() {
+			replayClock.Set(original.Now())
+		} else {
+			replayClock.Set(rec.at)
+		}
+		replay(repo, rec)
+	})
+	repo.AddClock(original)
+	if err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// drop a torn tail left by a crash in the middle of an append
+	if err := log.Truncate(validSize); err != nil {
+		_ = log.Close()
+		return nil, err
+	}
+	if _, err := log.Seek(validSize, 0); err != nil {
+		_ = log.Close()
+		return nil, err
+	}
+	d := &Repository{
+		repo:       repo,
+		dir:        dir,
+		generation: generation,
+		log:        log,
+		offset:     validSize,
+		options:    options,
+	}
+	removeStaleFiles(dir, generation)
+	if options.Sync == SyncInterval {
+		d.stop = make(chan struct{})
+		d.done = make(chan struct{})
+		go d.syncPeriodically()
+	}
+	return d, nil
+}
+
+// returns the underlying repository, mutating it directly bypasses the log
+func (d *Repository) Repository() *trie.Repository {
+	return d.repo
+}
+
+func (d *Repository) Insert(pattern string, value int) error {
+	_, err := d.append(record{op: opInsert, pattern: pattern, value: value})
+	return err
+}
+
+func (d *Repository) Inc(pattern string) error {
+	_, err := d.append(record{op: opInc, pattern: pattern})
+	return err
+}
+
+// see trie.Repository.IncBy
+func (d *Repository) IncBy(pattern string, delta int) error {
+	_, err := d.append(record{op: opIncBy, pattern: pattern, value: delta})
+	return err
+}
+
+// see trie.Repository.IncAt
+func (d *Repository) IncAt(pattern string, at time.Time) error {
+	_, err := d.append(record{op: opIncAt, pattern: pattern, incAt: at})
+	return err
+}
+
+// see trie.Repository.Reset, a call that cannot be logged resets nothing
+func (d *Repository) Reset(pattern string) (int, error) {
+	return d.append(record{op: opReset, pattern: pattern})
+}
+
+// see trie.Repository.ClearSelectorIncrement
+func (d *Repository) ClearSelectorIncrement(pattern string) (int, error) {
+	return d.append(record{op: opClearSelectorIncrement, pattern: pattern})
+}
+
+func (d *Repository) GetMap(pattern string) map[string]int {
+	return d.repo.GetMap(pattern)
+}
+
+func (d *Repository) GetValue(pattern string) int {
+	return d.repo.GetValue(pattern)
+}
+
+func (d *Repository) Contains(pattern string) bool {
+	return d.repo.Contains(pattern)
+}
+
+func (d *Repository) Size() int {
+	return d.repo.Size()
+}
+
+// writes a snapshot of the current state and starts a new, empty log
+// the previous snapshot and log are removed once the new ones are in place
+// a repository whose log failed accepts changes again
+func (d *Repository) Snapshot() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	next := d.generation + 1
+	snapshotPath := filepath.Join(d.dir, snapshotName(next))
+	if err := writeFileAtomically(snapshotPath, d.repo); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(filepath.Join(d.dir, logName(next)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(d.dir); err != nil {
+		_ = log.Close()
+		return err
+	}
+	_ = d.log.Close()
+	d.log = log
+	d.offset = 0
+	d.failed = nil
+	d.generation = next
+	removeStaleFiles(d.dir, next)
+	return nil
+}
+
+// flushes the log to stable storage
+func (d *Repository) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.log.Sync()
+}
+
+// stops the background sync, flushes and closes the log
+func (d *Repository) Close() error {
+	if d.stop != nil {
+		close(d.stop)
+		<-d.done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.log.Sync(); err != nil {
+		_ = d.log.Close()
+		return err
+	}
+	return d.log.Close()
+}
+
+// logs and applies "rec", a record that cannot be written, or flushed
+// with SyncAlways, is removed from the log and not applied, so a failed
+// call is never replayed, if it cannot be removed the repository
+// refuses every change with ErrLogFailed
+// returns the result of the call, for the calls returning a count
+func (d *Repository) append(rec record) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return 0, NewErrLogFailed(d.failed)
+	}
+	// taken under the lock, so the times in the log never go back
+	rec.at = d.repo.Clock().Now()
+	encoded := rec.encode()
+	_, err := d.log.Write(encoded)
+	if err == nil && d.options.Sync == SyncAlways {
+		err = d.log.Sync()
+	}
+	if err != nil {
+		d.undoAppend()
+		return 0, err
+	}
+	d.offset += int64(len(encoded))
+	return apply(d.repo, rec)
+}
+
+// truncates the log back to its last complete record
+// must hold the lock
+func (d *Repository) undoAppend() {
+	if err := d.log.Truncate(d.offset); err != nil {
+		d.failed = err
+		return
+	}
+	if _, err := d.log.Seek(d.offset, 0); err != nil {
+		d.failed = err
+	}
+}
+
+func (d *Repository) syncPeriodically() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			_ = d.Sync()
+		}
+	}
+}
+
+// applies a logged call to the repository
+// returns the count returned by Reset and ClearSelectorIncrement
+func apply(repo *trie.Repository, rec record) (int, error) {
+	switch rec.op {
+	case opInsert:
+		return 0, repo.Insert(rec.pattern, rec.value)
+	case opInc:
+		return 0, repo.Inc(rec.pattern)
+	case opIncBy:
+		return 0, repo.IncBy(rec.pattern, rec.value)
+	case opIncAt:
+		return 0, repo.IncAt(rec.pattern, rec.incAt)
+	case opReset:
+		return repo.Reset(rec.pattern), nil
+	case opClearSelectorIncrement:
+		return repo.ClearSelectorIncrement(rec.pattern)
+	}
+	return 0, fmt.Errorf("unknown log operation: %d", rec.op)
+}
+
+// applies a logged call while replaying, its result was already
+// reported when it was first made, calls that panicked then
+// (e.g. failed validation) did not mutate anything, so the panic is swallowed
+func replay(repo *trie.Repository, rec record) {
+	defer func() {
+		_ = recover()
+	}()
+	_, _ = apply(repo, rec)
+}
+
+func snapshotName(generation uint64) string {
+	return fmt.Sprintf("%s%016d", snapshotPrefix, generation)
+}
+
+func logName(generation uint64) string {
+	return fmt.Sprintf("%s%016d", logPrefix, generation)
+}
+
+// returns the generation of the latest complete snapshot
+// or zero if there is none
+func latestGeneration(dir string) (uint64, error) {
+	names, err := generationFiles(dir, snapshotPrefix)
+	if err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	return names[len(names)-1], nil
+}
+
+// returns the generations of the files in "dir" with the given prefix, ascending
+func generationFiles(dir string, prefix string) ([]uint64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	generations := make([]uint64, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, tempSuffix) {
+			continue
+		}
+		generation, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 64)
+		if err == nil {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	return generations, nil
+}
+
+// removes the snapshots and logs of older generations and leftover temporary files
+func removeStaleFiles(dir string, current uint64) {
+	for _, prefix := range []string{snapshotPrefix, logPrefix} {
+		generations, _ := generationFiles(dir, prefix)
+		for _, generation := range generations {
+			if generation < current {
+				_ = os.Remove(filepath.Join(dir, fmt.Sprintf("%s%016d", prefix, generation)))
+			}
+		}
+	}
+	temps, _ := filepath.Glob(filepath.Join(dir, "*"+tempSuffix))
+	for _, temp := range temps {
+		_ = os.Remove(temp)
+	}
+}
+
+func loadSnapshot(path string, repo *trie.Repository) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return repo.Load(file)
+}
+
+// writes the snapshot to a temporary file, flushes it and renames it into place
+func writeFileAtomically(path string, repo *trie.Repository) error {
+	temp := path + tempSuffix
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if err := repo.Save(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// directories cannot be synced on every platform
+	_ = file.Sync()
+	return nil
+}
@@ -0,0 +1,219 @@
+package durable
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/trie"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openSample(t *testing.T, dir string) *Repository {
+	repo, err := Open(dir, trie.New(), DefaultOptions())
+	assert.NoError(t, err)
+	return repo
+}
+
+func fillSample(repo *Repository) {
+	_ = repo.Insert("home/", 1)
+	_ = repo.Insert("home/bin/", 1)
+	_ = repo.Insert("home/bin/tar", 1)
+	_ = repo.Inc("home/bin/*")
+	_ = repo.Inc("home/bin/tar")
+}
+
+func TestOpen_ReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	fillSample(repo)
+	expected := repo.GetMap("*")
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, expected, reopened.GetMap("*"))
+	assert.Equal(t, 3, reopened.Size())
+	assert.Equal(t, 2, reopened.GetValue("home/bin/tar"))
+}
+
+func TestOpen_ReplaysFailedCallsIdentically(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	_ = repo.Insert("abc", 1)
+	assert.Error(t, repo.Insert("abc", 5))
+	assert.Error(t, repo.Inc("zzz"))
+	assert.Panics(t, func() { _ = repo.Inc("") })
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, map[string]int{"abc": 1}, reopened.GetMap("*"))
+}
+
+func TestOpen_DropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	fillSample(repo)
+	assert.NoError(t, repo.Close())
+
+	logPath := filepath.Join(dir, logName(0))
+	file, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	torn := record{op: opInsert, pattern: "torn", value: 1}.encode()
+	_, _ = file.Write(torn[:len(torn)-2])
+	_ = file.Close()
+
+	reopened := openSample(t, dir)
+	assert.False(t, reopened.Contains("torn"))
+	assert.NoError(t, reopened.Insert("after", 1))
+	assert.NoError(t, reopened.Close())
+
+	again := openSample(t, dir)
+	defer again.Close()
+	assert.True(t, again.Contains("after"))
+	assert.Equal(t, 4, again.Size())
+}
+
+func TestRepository_Snapshot_TruncatesLog(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	fillSample(repo)
+	assert.NoError(t, repo.Snapshot())
+	_ = repo.Inc("home/")
+	expected := repo.GetMap("*")
+	assert.NoError(t, repo.Close())
+
+	_, err := os.Stat(filepath.Join(dir, logName(0)))
+	assert.True(t, os.IsNotExist(err))
+	info, err := os.Stat(filepath.Join(dir, logName(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(record{op: opInc, pattern: "home/", at: time.Now()}.encode())), info.Size())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, expected, reopened.GetMap("*"))
+}
+
+func TestRepository_Snapshot_KeepsDeadlines(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	assert.NoError(t, repo.Repository().InsertWithTTL("session", 1, time.Hour))
+	assert.NoError(t, repo.Snapshot())
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	ttl, ok := reopened.Repository().TTL("session")
+	assert.True(t, ok)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+}
+
+func TestOpen_SyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.Sync = SyncInterval
+	repo, err := Open(dir, trie.New(), options)
+	assert.NoError(t, err)
+	fillSample(repo)
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, 3, reopened.Size())
+}
+
+func TestRepository_UndoAppend_KeepsLaterRecords(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	assert.NoError(t, repo.Insert("a", 1))
+	// a write that failed halfway through a record
+	_, err := repo.log.Write(record{op: opInsert, pattern: "torn", value: 1}.encode()[:3])
+	assert.NoError(t, err)
+	repo.undoAppend()
+	assert.NoError(t, repo.Insert("b", 2))
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, reopened.GetMap("*"))
+}
+
+func TestRepository_Append_FailedLog(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	assert.NoError(t, repo.Insert("a", 1))
+	// the log can neither be written nor truncated anymore
+	assert.NoError(t, repo.log.Close())
+	assert.Error(t, repo.Insert("b", 2))
+	err := repo.Insert("c", 3)
+	assert.IsType(t, &ErrLogFailed{}, err)
+	assert.False(t, repo.Contains("b"))
+	assert.False(t, repo.Contains("c"))
+
+	// a snapshot starts a new log
+	assert.NoError(t, repo.Snapshot())
+	assert.NoError(t, repo.Insert("d", 4))
+	assert.NoError(t, repo.Close())
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, map[string]int{"a": 1, "d": 4}, reopened.GetMap("*"))
+}
+
+func TestOpen_ReplaysEveryMutatingCall(t *testing.T) {
+	dir := t.TempDir()
+	repo := openSample(t, dir)
+	fillSample(repo)
+	assert.NoError(t, repo.IncBy("home/bin/tar", 5))
+	_ = repo.Insert("tmp/", 3)
+	_ = repo.Insert("tmp/a", 1)
+	_ = repo.Inc("tmp/*")
+	cleared, err := repo.ClearSelectorIncrement("tmp/*")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cleared)
+	reset, err := repo.Reset("home/*")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, reset)
+	expected := repo.GetMap("*")
+	assert.NoError(t, repo.Close())
+
+	reopened := openSample(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, expected, reopened.GetMap("*"))
+	assert.Equal(t, 0, reopened.GetValue("home/"))
+}
+
+func TestOpen_ReplaysAtCallTime(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	manual := clock.NewManual(start)
+	repo, err := Open(dir, trie.New().AddClock(manual).AddDecay(time.Hour), DefaultOptions())
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Insert("a", 100))
+	manual.Advance(time.Hour)
+	assert.Equal(t, 50, repo.GetValue("a"))
+	assert.NoError(t, repo.Close())
+
+	reopened, err := Open(dir, trie.New().AddClock(manual).AddDecay(time.Hour), DefaultOptions())
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, 50, reopened.GetValue("a"))
+	assert.Equal(t, manual, reopened.Repository().Clock())
+}
+
+func TestDecodeRecord_Untimed(t *testing.T) {
+	// records written before the calls were timed
+	payload := []byte{byte(opInsert), 0x06, 'a', 'b'}
+	rec, ok := decodeRecord(payload)
+	assert.True(t, ok)
+	assert.Equal(t, record{op: opInsert, pattern: "ab", value: 3}, rec)
+
+	at := time.Unix(0, 42)
+	encoded := record{op: opIncAt, pattern: "ab", at: at, incAt: at.Add(time.Second)}.encode()
+	rec, ok = decodeRecord(encoded[recordHeaderSize:])
+	assert.True(t, ok)
+	assert.Equal(t, opIncAt, rec.op)
+	assert.Equal(t, "ab", rec.pattern)
+	assert.True(t, at.Equal(rec.at))
+	assert.True(t, at.Add(time.Second).Equal(rec.incAt))
+}
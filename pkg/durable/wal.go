@@ -0,0 +1,148 @@
+package durable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// operation codes of the write-ahead log records
+type opCode byte
+
+const (
+	opInsert opCode = iota + 1
+	opInc
+	opIncBy
+	opIncAt
+	opReset
+	opClearSelectorIncrement
+)
+
+// set on the op code of the records carrying the time of their call
+// the records written before the calls were timed do not have it
+const opTimed opCode = 0x80
+
+// a single mutating call, as written to the write-ahead log
+type record struct {
+	op      opCode
+	pattern string
+	// value of Insert, or delta of IncBy
+	value int
+	// time of the call, so the call is replayed at that time
+	// on a decaying or time series repository, zero if unknown
+	at time.Time
+	// time of the increment of IncAt
+	incAt time.Time
+}
+
+// record layout:
+//
+//	payload length (uint32, little endian) | crc32 of payload (uint32, little endian) | payload
+//	payload: op code | value (varint) | time of the call | pattern
+//
+// times are varints of unix nanoseconds, the time of the call is only
+// there if the op code has opTimed set, and IncAt has the time
+// of its increment right after it
+const (
+	recordHeaderSize = 8
+	// upper bound of a record payload, protects against corrupt lengths
+	maxRecordSize = 1 << 24
+)
+
+func (r record) encode() []byte {
+	payload := make([]byte, 1+3*binary.MaxVarintLen64+len(r.pattern))
+	payload[0] = byte(r.op | opTimed)
+	n := 1 + binary.PutVarint(payload[1:], int64(r.value))
+	n += binary.PutVarint(payload[n:], r.at.UnixNano())
+	if r.op == opIncAt {
+		n += binary.PutVarint(payload[n:], r.incAt.UnixNano())
+	}
+	n += copy(payload[n:], r.pattern)
+	payload = payload[:n]
+
+	out := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(out[4:8], crc32.ChecksumIEEE(payload))
+	copy(out[recordHeaderSize:], payload)
+	return out
+}
+
+func decodeRecord(payload []byte) (record, bool) {
+	if len(payload) < 2 {
+		return record{}, false
+	}
+	rec := record{op: opCode(payload[0]) &^ opTimed}
+	value, n := binary.Varint(payload[1:])
+	if n <= 0 {
+		return record{}, false
+	}
+	rec.value = int(value)
+	rest := payload[1+n:]
+	if opCode(payload[0])&opTimed != 0 {
+		var ok bool
+		if rec.at, rest, ok = decodeTime(rest); !ok {
+			return record{}, false
+		}
+		if rec.op == opIncAt {
+			if rec.incAt, rest, ok = decodeTime(rest); !ok {
+				return record{}, false
+			}
+		}
+	}
+	rec.pattern = string(rest)
+	return rec, true
+}
+
+// returns the time at the start of "data" and the bytes after it
+func decodeTime(data []byte) (time.Time, []byte, bool) {
+	nanos, n := binary.Varint(data)
+	if n <= 0 {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(0, nanos), data[n:], true
+}
+
+// reads the records of the log file at "path" and passes them to "apply"
+// reading stops at the first torn or corrupt record
+// returns the offset right after the last valid record
+func replayLog(path string, apply func(record)) (int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var (
+		reader = bufio.NewReader(file)
+		offset int64
+		header [recordHeaderSize]byte
+	)
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return offset, nil
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return offset, nil
+		}
+		rec, ok := decodeRecord(payload)
+		if !ok {
+			return offset, nil
+		}
+		apply(rec)
+		offset += int64(recordHeaderSize + len(payload))
+	}
+}
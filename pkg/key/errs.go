@@ -49,3 +49,19 @@ func NewErrSelectorKeyNotAllowed(key Key) *ErrSelectorKeyNotAllowed {
 func (e *ErrSelectorKeyNotAllowed) Error() string {
 	return fmt.Sprintf(`key: "%s" is a selector and is not allowed`, e.key)
 }
+
+// error that is returned from the repository
+// when a key is empty after conversion
+type ErrEmptyKey struct {
+	pattern string
+}
+
+var _ error = (*ErrEmptyKey)(nil)
+
+func NewErrEmptyKey(pattern string) *ErrEmptyKey {
+	return &ErrEmptyKey{pattern: pattern}
+}
+
+func (e *ErrEmptyKey) Error() string {
+	return fmt.Sprintf(`pattern: "%s" results in an empty key`, e.pattern)
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func benchmarkPaths(size int) []string {
+	paths := make([]string, size)
+	for idx := range paths {
+		paths[idx] = "api/v1/users/" + strconv.Itoa(idx)
+	}
+	return paths
+}
+
+func BenchmarkWindowLimiter_Allow(b *testing.B) {
+	for _, size := range []int{10, 1000} {
+		b.Run(strconv.Itoa(size)+"Paths", func(b *testing.B) {
+			paths := benchmarkPaths(size)
+			manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+			limiter := NewWindowLimiter(time.Second,
+				Limit{Pattern: "api/*", Max: b.N + 1, Window: 10 * time.Second},
+				Limit{Pattern: "api/v1/users/*", Max: b.N + 1, Window: time.Minute},
+			).AddClock(manual)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%1000 == 0 {
+					manual.Advance(100 * time.Millisecond)
+				}
+				limiter.Allow(paths[i%len(paths)])
+			}
+		})
+	}
+}
+
+func BenchmarkTokenBucketLimiter_Allow(b *testing.B) {
+	for _, size := range []int{10, 1000} {
+		b.Run(strconv.Itoa(size)+"Paths", func(b *testing.B) {
+			paths := benchmarkPaths(size)
+			manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+			limiter := NewTokenBucketLimiter(
+				Rate{Pattern: "api/*", PerSecond: 100, Burst: 200},
+				Rate{Pattern: "api/v1/users/*", PerSecond: 10, Burst: 10},
+			).AddClock(manual)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%1000 == 0 {
+					manual.Advance(100 * time.Millisecond)
+				}
+				limiter.Allow(paths[i%len(paths)])
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/intenvy/memoir/pkg/trie"
+	"sync"
+	"time"
+)
+
+// token bucket of the paths matching Pattern, every path has its own bucket
+// holding up to Burst tokens, refilled with PerSecond tokens every second
+// and a hit takes a token
+type Rate struct {
+	// selector of the paths, e.g. "api/search/*", or a single path
+	Pattern   string
+	PerSecond float64
+	Burst     int
+}
+
+type tokenBucket struct {
+	tokens float64
+	// time of the last refill
+	updated time.Time
+	rate    *Rate
+}
+
+// refills the bucket with the tokens earned since its last refill
+func (tb *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(tb.updated); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate.PerSecond
+		if burst := float64(tb.rate.Burst); tb.tokens > burst {
+			tb.tokens = burst
+		}
+		tb.updated = now
+	}
+}
+
+// token bucket limiter, a path is limited by the most specific rate
+// matching it: the rate of the path itself, or else the rate
+// of its longest prefix, found with a lookup in a trie of the prefixes
+// the buckets are refilled lazily when their path is hit
+// it is safe for concurrent use
+type TokenBucketLimiter struct {
+	mutex sync.Mutex
+	rates []Rate
+	// rates of single paths, by path
+	paths map[string]int
+	// prefixes of the selectors, their values are indexes of rates
+	prefixes *trie.Repository
+	// rate of "*", -1 if there is none
+	fallback int
+	buckets  map[string]*tokenBucket
+	clock    clock.Clock
+}
+
+func NewTokenBucketLimiter(rates ...Rate) *TokenBucketLimiter {
+	tl := &TokenBucketLimiter{
+		rates:    rates,
+		paths:    make(map[string]int),
+		prefixes: trie.New(),
+		fallback: -1,
+		buckets:  make(map[string]*tokenBucket),
+		clock:    clock.System(),
+	}
+	// a later rate of the same pattern replaces the former
+	selectors := make(map[string]int)
+	for idx, rate := range rates {
+		entry := key.New(rate.Pattern, key.NewConverterPipeline(), key.NewValidatorPipeline())
+		switch {
+		case entry.IsRaw():
+			tl.paths[string(*entry)] = idx
+		case entry.Size() == 1:
+			tl.fallback = idx
+		default:
+			selectors[string(*entry)[:entry.Size()-1]] = idx
+		}
+	}
+	for prefix, idx := range selectors {
+		_ = tl.prefixes.Insert(prefix, idx)
+	}
+	return tl
+}
+
+func (tl *TokenBucketLimiter) AddClock(c clock.Clock) *TokenBucketLimiter {
+	tl.clock = c
+	return tl
+}
+
+// returns the most specific rate of "path" and false if no rate matches it
+func (tl *TokenBucketLimiter) rateOf(path string) (*Rate, bool) {
+	if idx, ok := tl.paths[path]; ok {
+		return &tl.rates[idx], true
+	}
+	if _, idx, ok := tl.prefixes.LongestPrefixOf(path); ok {
+		return &tl.rates[idx], true
+	}
+	if tl.fallback >= 0 {
+		return &tl.rates[tl.fallback], true
+	}
+	return nil, false
+}
+
+// same as AllowN with a single token
+func (tl *TokenBucketLimiter) Allow(path string) bool {
+	return tl.AllowN(path, 1)
+}
+
+// takes "n" tokens from the bucket of "path" and returns true
+// if it held them, otherwise no token is taken
+// a path that no rate matches is always allowed
+// "path" is not converted nor validated
+func (tl *TokenBucketLimiter) AllowN(path string, n int) bool {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	now := tl.clock.Now()
+	bucket, ok := tl.buckets[path]
+	if !ok {
+		rate, limited := tl.rateOf(path)
+		if !limited {
+			return true
+		}
+		bucket = &tokenBucket{tokens: float64(rate.Burst), updated: now, rate: rate}
+		tl.buckets[path] = bucket
+	}
+	bucket.refill(now)
+	if bucket.tokens < float64(n) {
+		return false
+	}
+	bucket.tokens -= float64(n)
+	return true
+}
+
+// returns the tokens left in the bucket of "path" and false
+// if no rate matches it
+func (tl *TokenBucketLimiter) Tokens(path string) (float64, bool) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	bucket, ok := tl.buckets[path]
+	if !ok {
+		rate, limited := tl.rateOf(path)
+		if !limited {
+			return 0, false
+		}
+		return float64(rate.Burst), true
+	}
+	bucket.refill(tl.clock.Now())
+	return bucket.tokens, true
+}
+
+// forgets the buckets that are full again, they are created anew
+// when their path is hit, so the buckets of paths that are not hit
+// anymore do not pile up
+// returns the number of forgotten buckets
+func (tl *TokenBucketLimiter) Prune() int {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	now := tl.clock.Now()
+	pruned := 0
+	for path, bucket := range tl.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(bucket.rate.Burst) {
+			delete(tl.buckets, path)
+			pruned++
+		}
+	}
+	return pruned
+}
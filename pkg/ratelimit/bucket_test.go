@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func buildTokenBucketLimiter(rates ...Rate) (*TokenBucketLimiter, *clock.Manual) {
+	manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewTokenBucketLimiter(rates...).AddClock(manual), manual
+}
+
+func TestTokenBucketLimiter_Allow(t *testing.T) {
+	limiter, manual := buildTokenBucketLimiter(Rate{Pattern: "api/*", PerSecond: 2, Burst: 3})
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allow("api/users/42"))
+	}
+	assert.False(t, limiter.Allow("api/users/42"))
+	// every path has its own bucket
+	assert.True(t, limiter.Allow("api/users/43"))
+	// paths that no rate matches are not limited
+	assert.True(t, limiter.Allow("static/logo.png"))
+
+	manual.Advance(500 * time.Millisecond)
+	assert.True(t, limiter.Allow("api/users/42"))
+	assert.False(t, limiter.Allow("api/users/42"))
+	// refills up to the burst
+	manual.Advance(time.Hour)
+	tokens, ok := limiter.Tokens("api/users/42")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, tokens)
+	assert.False(t, limiter.AllowN("api/users/42", 4))
+	assert.True(t, limiter.AllowN("api/users/42", 3))
+}
+
+func TestTokenBucketLimiter_MostSpecificRate(t *testing.T) {
+	limiter, _ := buildTokenBucketLimiter(
+		Rate{Pattern: "*", PerSecond: 1, Burst: 1},
+		Rate{Pattern: "api/*", PerSecond: 100, Burst: 200},
+		Rate{Pattern: "api/search/*", PerSecond: 10, Burst: 10},
+		Rate{Pattern: "api/search/export", PerSecond: 1, Burst: 2},
+	)
+	for path, burst := range map[string]float64{
+		"api/users/42":      200,
+		"api/search/q":      10,
+		"api/search/export": 2,
+		"api/search/":       10,
+		"api/":              200,
+		"static/logo.png":   1,
+	} {
+		tokens, ok := limiter.Tokens(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, burst, tokens, path)
+	}
+}
+
+func TestTokenBucketLimiter_Prune(t *testing.T) {
+	limiter, manual := buildTokenBucketLimiter(Rate{Pattern: "api/*", PerSecond: 1, Burst: 2})
+	limiter.Allow("api/a")
+	limiter.AllowN("api/b", 2)
+	limiter.Allow("static/c")
+	manual.Advance(time.Second)
+	assert.Equal(t, 1, limiter.Prune())
+	assert.Equal(t, 1, len(limiter.buckets))
+	tokens, _ := limiter.Tokens("api/b")
+	assert.Equal(t, 1.0, tokens)
+}
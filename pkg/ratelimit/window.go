@@ -0,0 +1,196 @@
+// Package ratelimit limits the hits on paths, e.g. "api/v1/users/42",
+// per prefix of the paths, with counters kept in tries
+package ratelimit
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/intenvy/memoir/pkg/trie"
+	"strings"
+	"sync"
+	"time"
+)
+
+// most hits allowed within a sliding window on the paths matching Pattern
+type Limit struct {
+	// selector of the limited paths, e.g. "api/v1/*", or a single path
+	// the hits on every matching path count together
+	Pattern string
+	Max     int
+	// rounded up to a multiple of the resolution of the limiter
+	Window time.Duration
+}
+
+type windowLimit struct {
+	Limit
+	prefix   string
+	selector bool
+	// number of buckets covered by the window
+	buckets int64
+	// hits in the window as of the interval "countedAt", read from the
+	// buckets once per interval, the buckets before the current one
+	// no longer change and the hits of the current one are counted along
+	countedAt int64
+	closed    int
+	oldest    int
+	current   int
+}
+
+func (wl *windowLimit) matches(path string) bool {
+	if wl.selector {
+		return strings.HasPrefix(path, wl.prefix)
+	}
+	return path == wl.prefix
+}
+
+// sliding window limiter, the hits are counted per path in a ring
+// of tries, one per interval of "resolution", and the count of a window
+// is the sum of its buckets, the oldest bucket weighted by the part
+// of it still in the window, so a finer resolution is more precise
+// the buckets of a limit are read once per interval, when it is first hit
+// it is safe for concurrent use
+type WindowLimiter struct {
+	mutex      sync.Mutex
+	resolution time.Duration
+	limits     []windowLimit
+	buckets    []*trie.Repository
+	// interval of every bucket, since the unix epoch
+	intervals []int64
+	clock     clock.Clock
+}
+
+// panics if "resolution" is not positive
+func NewWindowLimiter(resolution time.Duration, limits ...Limit) *WindowLimiter {
+	if resolution <= 0 {
+		panic("ratelimit: the resolution of a window limiter must be positive")
+	}
+	wl := &WindowLimiter{resolution: resolution, clock: clock.System()}
+	longest := int64(1)
+	for _, limit := range limits {
+		entry := key.New(limit.Pattern, key.NewConverterPipeline(), key.NewValidatorPipeline())
+		compiled := windowLimit{Limit: limit, prefix: string(*entry), selector: entry.IsSelector(), countedAt: -1}
+		if compiled.selector {
+			compiled.prefix = compiled.prefix[:entry.Size()-1]
+		}
+		compiled.buckets = int64((limit.Window + resolution - 1) / resolution)
+		if compiled.buckets < 1 {
+			compiled.buckets = 1
+		}
+		if compiled.buckets > longest {
+			longest = compiled.buckets
+		}
+		wl.limits = append(wl.limits, compiled)
+	}
+	// the oldest bucket of the longest window is still partly in it
+	wl.buckets = make([]*trie.Repository, longest+1)
+	wl.intervals = make([]int64, longest+1)
+	for idx := range wl.buckets {
+		wl.buckets[idx] = trie.New()
+		wl.intervals[idx] = -1
+	}
+	return wl
+}
+
+func (wl *WindowLimiter) AddClock(c clock.Clock) *WindowLimiter {
+	wl.clock = c
+	return wl
+}
+
+// returns the bucket of "interval", emptied if it held an older interval
+// must hold the mutex
+func (wl *WindowLimiter) bucket(interval int64) *trie.Repository {
+	idx := interval % int64(len(wl.buckets))
+	if wl.intervals[idx] != interval {
+		wl.buckets[idx] = trie.New()
+		wl.intervals[idx] = interval
+	}
+	return wl.buckets[idx]
+}
+
+// returns the hits on "pattern" in the bucket of "interval"
+// must hold the mutex
+func (wl *WindowLimiter) hits(pattern string, interval int64) int {
+	idx := interval % int64(len(wl.buckets))
+	if wl.intervals[idx] != interval {
+		return 0
+	}
+	return wl.buckets[idx].GetValue(pattern)
+}
+
+// returns the hits on "pattern" in the buckets of the intervals from "from" to "to"
+// must hold the mutex
+func (wl *WindowLimiter) sum(pattern string, from, to int64) int {
+	total := 0
+	for interval := from; interval <= to; interval++ {
+		total += wl.hits(pattern, interval)
+	}
+	return total
+}
+
+// returns the weight of the oldest bucket of a window at "now",
+// the part of it that is still in the window
+func (wl *WindowLimiter) overlap(now time.Time) float64 {
+	return 1 - float64(now.UnixNano()%int64(wl.resolution))/float64(wl.resolution)
+}
+
+// returns the hits within the window of "limit"
+// must hold the mutex
+func (wl *WindowLimiter) count(limit *windowLimit, now time.Time) float64 {
+	current := now.UnixNano() / int64(wl.resolution)
+	if limit.countedAt != current {
+		limit.closed = wl.sum(limit.Pattern, current-limit.buckets+1, current-1)
+		limit.oldest = wl.hits(limit.Pattern, current-limit.buckets)
+		limit.current = wl.hits(limit.Pattern, current)
+		limit.countedAt = current
+	}
+	total := limit.closed + limit.current
+	return float64(total) + wl.overlap(now)*float64(limit.oldest)
+}
+
+// counts a hit on "path" and returns true if it is within every limit
+// matching "path", a hit that is not allowed is not counted
+// "path" is not converted nor validated, and must not be empty
+func (wl *WindowLimiter) Allow(path string) bool {
+	if path == "" {
+		return false
+	}
+	wl.mutex.Lock()
+	defer wl.mutex.Unlock()
+	now := wl.clock.Now()
+	for idx := range wl.limits {
+		limit := &wl.limits[idx]
+		if limit.matches(path) && wl.count(limit, now)+1 > float64(limit.Max) {
+			return false
+		}
+	}
+	current := wl.bucket(now.UnixNano() / int64(wl.resolution))
+	if err := current.Inc(path); err != nil {
+		_ = current.Insert(path, 1)
+	}
+	for idx := range wl.limits {
+		// every matching limit has been counted in the current interval
+		if limit := &wl.limits[idx]; limit.matches(path) {
+			limit.current++
+		}
+	}
+	return true
+}
+
+// returns the estimated hits on the paths matching "pattern"
+// within the last "window", as the limits count them
+// "window" is at most the longest window of the limits
+func (wl *WindowLimiter) Count(pattern string, window time.Duration) int {
+	buckets := int64((window + wl.resolution - 1) / wl.resolution)
+	if buckets < 1 {
+		buckets = 1
+	}
+	if max := int64(len(wl.buckets)) - 1; buckets > max {
+		buckets = max
+	}
+	wl.mutex.Lock()
+	defer wl.mutex.Unlock()
+	now := wl.clock.Now()
+	current := now.UnixNano() / int64(wl.resolution)
+	total := float64(wl.sum(pattern, current-buckets+1, current))
+	return int(total + wl.overlap(now)*float64(wl.hits(pattern, current-buckets)))
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func buildWindowLimiter(limits ...Limit) (*WindowLimiter, *clock.Manual) {
+	manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewWindowLimiter(time.Second, limits...).AddClock(manual), manual
+}
+
+func TestWindowLimiter_Allow(t *testing.T) {
+	limiter, manual := buildWindowLimiter(Limit{Pattern: "api/v1/users/*", Max: 3, Window: 10 * time.Second})
+	assert.True(t, limiter.Allow("api/v1/users/42"))
+	assert.True(t, limiter.Allow("api/v1/users/43"))
+	manual.Advance(5 * time.Second)
+	assert.True(t, limiter.Allow("api/v1/users/42"))
+	// the hits on every matching path count together
+	assert.False(t, limiter.Allow("api/v1/users/44"))
+	// paths without a limit are always allowed
+	assert.True(t, limiter.Allow("api/v1/orders/1"))
+	assert.Equal(t, 3, limiter.Count("api/v1/users/*", 10*time.Second))
+	assert.Equal(t, 2, limiter.Count("api/v1/users/42", 10*time.Second))
+	assert.Equal(t, 4, limiter.Count("api/*", 10*time.Second))
+}
+
+func TestWindowLimiter_Slides(t *testing.T) {
+	limiter, manual := buildWindowLimiter(Limit{Pattern: "api/*", Max: 2, Window: 2 * time.Second})
+	assert.True(t, limiter.Allow("api/a"))
+	assert.True(t, limiter.Allow("api/b"))
+	assert.False(t, limiter.Allow("api/c"))
+	// both hits are in the oldest bucket, half of it is still in the window
+	manual.Advance(2*time.Second + 500*time.Millisecond)
+	assert.Equal(t, 1, limiter.Count("api/*", 2*time.Second))
+	assert.True(t, limiter.Allow("api/c"))
+	// counts as 0.5 + 1
+	manual.Advance(250 * time.Millisecond)
+	assert.False(t, limiter.Allow("api/d"))
+	manual.Advance(250 * time.Millisecond)
+	assert.True(t, limiter.Allow("api/d"))
+	manual.Advance(time.Hour)
+	assert.Equal(t, 0, limiter.Count("api/*", 2*time.Second))
+	assert.True(t, limiter.Allow("api/d"))
+	assert.True(t, limiter.Allow("api/e"))
+}
+
+func TestWindowLimiter_NestedLimits(t *testing.T) {
+	limiter, _ := buildWindowLimiter(
+		Limit{Pattern: "api/*", Max: 5, Window: time.Minute},
+		Limit{Pattern: "api/search/*", Max: 1, Window: time.Second},
+		Limit{Pattern: "api/login", Max: 2, Window: time.Minute},
+	)
+	assert.True(t, limiter.Allow("api/search/q"))
+	assert.False(t, limiter.Allow("api/search/r"))
+	assert.True(t, limiter.Allow("api/login"))
+	assert.True(t, limiter.Allow("api/login"))
+	assert.False(t, limiter.Allow("api/login"))
+	assert.True(t, limiter.Allow("api/users"))
+	assert.True(t, limiter.Allow("api/users"))
+	// the limit of "api/*" is reached, refused hits are not counted
+	assert.False(t, limiter.Allow("api/users"))
+	assert.Equal(t, 5, limiter.Count("api/*", time.Minute))
+	assert.False(t, limiter.Allow(""))
+}
+
+func TestNewWindowLimiter_InvalidResolution(t *testing.T) {
+	limit := Limit{Pattern: "api/*", Max: 3, Window: time.Second}
+	assert.Panics(t, func() { NewWindowLimiter(0, limit) })
+	assert.Panics(t, func() { NewWindowLimiter(-time.Second, limit) })
+}
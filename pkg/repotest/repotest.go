@@ -0,0 +1,314 @@
+// Package repotest holds the tests and benchmarks shared by every
+// pkg.KeyValueRepository backend, so backends can be checked against
+// the same semantics and compared on the same workloads
+package repotest
+
+import (
+	"fmt"
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// returns a new, empty repository with the default converter and validator
+type Factory func() pkg.KeyValueRepository
+
+// runs the shared tests against the repositories built by "factory"
+func Run(t *testing.T, factory Factory) {
+	t.Run("Insert", func(t *testing.T) { testInsert(t, factory) })
+	t.Run("Inc", func(t *testing.T) { testInc(t, factory) })
+	t.Run("IncSelector", func(t *testing.T) { testIncSelector(t, factory) })
+	t.Run("GetMap", func(t *testing.T) { testGetMap(t, factory) })
+	t.Run("GetValue", func(t *testing.T) { testGetValue(t, factory) })
+	t.Run("Contains", func(t *testing.T) { testContains(t, factory) })
+	t.Run("Model", func(t *testing.T) { testModel(t, factory) })
+}
+
+func fill(repo pkg.KeyValueRepository, value int, keys ...string) {
+	for _, k := range keys {
+		_ = repo.Insert(k, value)
+	}
+}
+
+func testInsert(t *testing.T, factory Factory) {
+	repo := factory()
+	assert.Nil(t, repo.Insert("abc", 1))
+	assert.Nil(t, repo.Insert("ab", 2))
+	assert.Nil(t, repo.Insert("abd", 3))
+	assert.IsType(t, &key.ErrKeyAlreadyExists{}, repo.Insert("ab", 4))
+	assert.IsType(t, &key.ErrSelectorKeyNotAllowed{}, repo.Insert("ab*", 4))
+	assert.Equal(t, 3, repo.Size())
+	assert.Equal(t, 2, repo.GetValue("ab"))
+}
+
+func testInc(t *testing.T, factory Factory) {
+	repo := factory()
+	fill(repo, 10, "abc", "abd")
+	assert.Nil(t, repo.Inc("abc"))
+	assert.Equal(t, 11, repo.GetValue("abc"))
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.Inc("ab"))
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.Inc("xyz"))
+	assert.Equal(t, 2, repo.Size())
+}
+
+func testIncSelector(t *testing.T, factory Factory) {
+	repo := factory()
+	fill(repo, 1, "t1", "t2", "t11")
+	assert.Nil(t, repo.Inc("t1*"))
+	// the path exists but is not a key, the increment is still recorded
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.Inc("t*"))
+	// the path does not exist, nothing is recorded
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.Inc("x*"))
+	assert.Equal(t, map[string]int{"t1": 1, "t2": 1, "t11": 1, "t*": 1, "t1*": 1}, repo.GetMap("*"))
+	assert.Equal(t, 2, repo.GetValue("t1"))
+	assert.Equal(t, 1, repo.GetValue("t"))
+	assert.Equal(t, 8, repo.GetValue("t*"))
+	assert.Equal(t, 3, repo.Size())
+}
+
+func testGetMap(t *testing.T, factory Factory) {
+	repo := factory()
+	fill(repo, 5, "home/a", "home/b", "house", "work/a")
+	assert.Equal(t, map[string]int{"home/a": 5, "home/b": 5}, repo.GetMap("home/*"))
+	assert.Equal(t, map[string]int{"home/a": 5, "home/b": 5, "house": 5}, repo.GetMap("ho*"))
+	assert.Equal(t, map[string]int{"house": 5}, repo.GetMap("house"))
+	assert.Equal(t, map[string]int{}, repo.GetMap("hou"))
+	assert.Equal(t, map[string]int{}, repo.GetMap("x*"))
+	assert.Len(t, repo.GetMap("*"), 4)
+}
+
+func testGetValue(t *testing.T, factory Factory) {
+	repo := factory()
+	fill(repo, 2, "home/a", "home/b", "house")
+	assert.Equal(t, 6, repo.GetValue("*"))
+	assert.Equal(t, 4, repo.GetValue("home/*"))
+	assert.Equal(t, 2, repo.GetValue("home/a"))
+	assert.Equal(t, 0, repo.GetValue("home"))
+	assert.Equal(t, 0, repo.GetValue("hom*e"))
+}
+
+func testContains(t *testing.T, factory Factory) {
+	repo := factory()
+	fill(repo, 1, "abcdaa", "abcdab", "abcdee")
+	assert.True(t, repo.Contains("abcdab"))
+	assert.True(t, repo.Contains("ab*"))
+	assert.True(t, repo.Contains("abcde*"))
+	assert.True(t, repo.Contains("*"))
+	assert.False(t, repo.Contains("abc"))
+	assert.False(t, repo.Contains("abx*"))
+	assert.False(t, factory().Contains("*"))
+}
+
+// reference implementation of the semantics of the repositories,
+// kept as plain maps so it is obviously right rather than fast
+type model struct {
+	keys      map[string]int
+	selectors map[string]int
+}
+
+// checks whether any key or pending selector lies at or below "prefix"
+func (m *model) pathExists(prefix string) bool {
+	for k := range m.keys {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	for s := range m.selectors {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *model) insert(pattern string, value int) bool {
+	if _, ok := m.keys[pattern]; ok {
+		return false
+	}
+	m.keys[pattern] = value
+	return true
+}
+
+func (m *model) inc(pattern string) bool {
+	if !strings.HasSuffix(pattern, string(key.SelectorChar)) {
+		if _, ok := m.keys[pattern]; !ok {
+			return false
+		}
+		m.keys[pattern]++
+		return true
+	}
+	prefix := pattern[:len(pattern)-1]
+	if prefix != "" && !m.pathExists(prefix) {
+		return false
+	}
+	m.selectors[prefix]++
+	_, ok := m.keys[prefix]
+	return ok
+}
+
+func (m *model) getValue(pattern string) int {
+	if !strings.HasSuffix(pattern, string(key.SelectorChar)) {
+		return m.keys[pattern] + m.selectors[pattern]
+	}
+	prefix := pattern[:len(pattern)-1]
+	total := 0
+	for k, v := range m.keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		total += v
+		for s, inc := range m.selectors {
+			if strings.HasPrefix(s, prefix) && strings.HasPrefix(k, s) {
+				total += inc
+			}
+		}
+	}
+	return total
+}
+
+func (m *model) getMap(pattern string) map[string]int {
+	out := make(map[string]int)
+	if !strings.HasSuffix(pattern, string(key.SelectorChar)) {
+		if v, ok := m.keys[pattern]; ok {
+			out[pattern] = v
+		}
+		return out
+	}
+	prefix := pattern[:len(pattern)-1]
+	for k, v := range m.keys {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = v
+		}
+	}
+	for s, v := range m.selectors {
+		if strings.HasPrefix(s, prefix) {
+			out[s+string(key.SelectorChar)] = v
+		}
+	}
+	return out
+}
+
+func (m *model) contains(pattern string) bool {
+	if !strings.HasSuffix(pattern, string(key.SelectorChar)) {
+		_, ok := m.keys[pattern]
+		return ok
+	}
+	return m.pathExists(pattern[:len(pattern)-1])
+}
+
+// returns a random key over a small alphabet, so keys share prefixes
+// and the selector character also shows up inside keys
+func randomKey(rnd *rand.Rand, maxSize int) string {
+	const alphabet = "ab/*"
+	var sb strings.Builder
+	size := 1 + rnd.Intn(maxSize)
+	for i := 0; i < size; i++ {
+		sb.WriteByte(alphabet[rnd.Intn(len(alphabet))])
+	}
+	return sb.String()
+}
+
+func randomPattern(rnd *rand.Rand) string {
+	if rnd.Intn(2) == 0 {
+		for {
+			if k := randomKey(rnd, 5); !strings.HasSuffix(k, "*") {
+				return k
+			}
+		}
+	}
+	prefix := randomKey(rnd, 4)
+	return prefix[:rnd.Intn(len(prefix)+1)] + "*"
+}
+
+// runs random operations against the repository and the model
+// and checks that both always agree
+func testModel(t *testing.T, factory Factory) {
+	for seed := int64(0); seed < 20; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		repo := factory()
+		ref := &model{keys: make(map[string]int), selectors: make(map[string]int)}
+		for step := 0; step < 300; step++ {
+			pattern := randomPattern(rnd)
+			where := fmt.Sprintf("seed %d, step %d, pattern %q", seed, step, pattern)
+			switch rnd.Intn(6) {
+			case 0:
+				if strings.HasSuffix(pattern, "*") {
+					continue
+				}
+				value := rnd.Intn(100)
+				assert.Equal(t, ref.insert(pattern, value), repo.Insert(pattern, value) == nil, where)
+			case 1:
+				assert.Equal(t, ref.inc(pattern), repo.Inc(pattern) == nil, where)
+			case 2:
+				assert.Equal(t, ref.getValue(pattern), repo.GetValue(pattern), where)
+			case 3:
+				assert.Equal(t, ref.getMap(pattern), repo.GetMap(pattern), where)
+			case 4:
+				assert.Equal(t, ref.contains(pattern), repo.Contains(pattern), where)
+			case 5:
+				assert.Equal(t, len(ref.keys), repo.Size(), where)
+			}
+		}
+		assert.Equal(t, ref.getMap("*"), repo.GetMap("*"), fmt.Sprintf("seed %d", seed))
+	}
+}
+
+// returns "size" realistic path keys sharing long prefixes,
+// e.g. "org/org-3/team/team-1/svc/svc-4/users/9e3779b1"
+func Dataset(size int) []string {
+	keys := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		keys = append(keys, fmt.Sprintf(
+			"org/org-%d/team/team-%d/svc/svc-%d/users/%08x",
+			i%7, (i/7)%5, (i/35)%11, uint32(i)*2654435761,
+		))
+	}
+	return keys
+}
+
+// runs the shared benchmarks against the repositories built by "factory"
+func Benchmark(b *testing.B, factory Factory) {
+	keys := Dataset(100000)
+	filled := factory()
+	fill(filled, 1, keys...)
+
+	b.Run("Insert", func(b *testing.B) {
+		b.ReportAllocs()
+		var repo pkg.KeyValueRepository
+		for i := 0; i < b.N; i++ {
+			if i%len(keys) == 0 {
+				b.StopTimer()
+				repo = factory()
+				b.StartTimer()
+			}
+			_ = repo.Insert(keys[i%len(keys)], 1)
+		}
+	})
+	b.Run("GetValue", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			filled.GetValue(keys[i%len(keys)])
+		}
+	})
+	b.Run("Inc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = filled.Inc(keys[i%len(keys)])
+		}
+	})
+	b.Run("GetValueSelector", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			filled.GetValue(fmt.Sprintf("org/org-%d/team/team-%d/*", i%7, i%5))
+		}
+	})
+	b.Run("GetMapSelector", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			filled.GetMap(fmt.Sprintf("org/org-%d/team/team-%d/svc/svc-%d/*", i%7, i%5, i%11))
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package trie
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// returns "size" realistic, deeply nested path keys
+// sharing long prefixes and ending in unique identifiers,
+// e.g. "org/org-3/team/team-1/svc/svc-4/api/v2/users/9e3779b1/profile"
+func buildPathDataset(size int) []string {
+	keys := make([]string, 0, size)
+	for i := 0; len(keys) < size; i++ {
+		keys = append(keys, fmt.Sprintf(
+			"org/org-%d/team/team-%d/svc/svc-%d/api/v%d/users/%08x/profile",
+			i%7, (i/7)%5, (i/35)%11, (i/385)%3, uint32(i)*2654435761,
+		))
+	}
+	return keys
+}
+
+// returns the keys of "size" files and of every directory above them,
+// so most keys are prefixes of other keys
+// e.g. "org/", "org/org-3/", ..., "org/org-3/team/team-1/svc/svc-4/main.go"
+func buildNestedPathDataset(size int) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0, size)
+	for _, file := range buildPathDataset(size) {
+		for idx, char := range file {
+			if char == '/' && !seen[file[:idx+1]] {
+				seen[file[:idx+1]] = true
+				keys = append(keys, file[:idx+1])
+			}
+		}
+		keys = append(keys, file)
+	}
+	return keys
+}
+
+func buildDatasetTrie(keys []string) *Repository {
+	repo := New()
+	for _, k := range keys {
+		// keys usually arrive in fresh buffers (e.g. requests),
+		// so the repository does not share the dataset strings
+		_ = repo.Insert(string([]byte(k)), 1)
+	}
+	return repo
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func BenchmarkRepository_Memory(b *testing.B) {
+	keys := buildPathDataset(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		repo := buildDatasetTrie(keys)
+		after := heapInUse()
+		b.ReportMetric(float64(after-before)/float64(len(keys)), "heap-B/key")
+		runtime.KeepAlive(repo)
+	}
+}
+
+func BenchmarkRepository_MemoryNestedKeys(b *testing.B) {
+	keys := buildNestedPathDataset(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		repo := buildDatasetTrie(keys)
+		after := heapInUse()
+		b.ReportMetric(float64(after-before)/float64(len(keys)), "heap-B/key")
+		runtime.KeepAlive(repo)
+	}
+}
+
+func BenchmarkFrozen_Memory(b *testing.B) {
+	keys := buildPathDataset(100000)
+	repo := buildDatasetTrie(keys)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		frozen := repo.Freeze()
+		after := heapInUse()
+		b.ReportMetric(float64(after-before)/float64(len(keys)), "heap-B/key")
+		runtime.KeepAlive(frozen)
+	}
+}
+
+func BenchmarkRepository_Insert(b *testing.B) {
+	keys := buildPathDataset(b.N)
+	repo := New()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = repo.Insert(keys[i], 1)
+	}
+}
+
+func BenchmarkRepository_GetValue(b *testing.B) {
+	keys := buildPathDataset(100000)
+	repo := buildDatasetTrie(keys)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = repo.GetValue(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkRepository_GetMap(b *testing.B) {
+	keys := buildPathDataset(100000)
+	repo := buildDatasetTrie(keys)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = repo.GetMap("org/org-3/team/team-1/*")
+	}
+}
+
+func BenchmarkFrozen_GetValue(b *testing.B) {
+	keys := buildPathDataset(100000)
+	frozen := buildDatasetTrie(keys).Freeze()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = frozen.GetValue(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkFrozen_GetMap(b *testing.B) {
+	keys := buildPathDataset(100000)
+	frozen := buildDatasetTrie(keys).Freeze()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = frozen.GetMap("org/org-3/team/team-1/*")
+	}
+}
+
+func BenchmarkRepository_IncSelector(b *testing.B) {
+	keys := buildPathDataset(100000)
+	repo := buildDatasetTrie(keys)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = repo.Inc("org/org-3/team/*")
+	}
+}
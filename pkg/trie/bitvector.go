@@ -0,0 +1,147 @@
+package trie
+
+import "math/bits"
+
+const (
+	// number of words covered by a single rank sample
+	wordsPerRankBlock = 8
+	bitsPerRankBlock  = wordsPerRankBlock * 64
+	// number of zeros between two select samples
+	zerosPerSelectSample = 512
+)
+
+// immutable sequence of bits supporting rank and select queries
+// the ranks are sampled once per block of words, a query counts
+// the remaining bits of at most one block
+type bitVector struct {
+	words []uint64
+	// number of ones before every block of words
+	ranks []uint32
+	// block holding every zerosPerSelectSample-th zero,
+	// narrows the search for the block of a zero
+	zeroBlocks []uint32
+	size       int
+}
+
+// builds bit vectors one bit at a time
+type bitVectorBuilder struct {
+	words []uint64
+	size  int
+}
+
+func (b *bitVectorBuilder) push(bit bool) {
+	if b.size%64 == 0 {
+		b.words = append(b.words, 0)
+	}
+	if bit {
+		b.words[b.size/64] |= 1 << uint(b.size%64)
+	}
+	b.size++
+}
+
+func (b *bitVectorBuilder) build() bitVector {
+	return newBitVector(b.words, b.size)
+}
+
+// wraps "words" holding "size" bits and samples their ranks
+func newBitVector(words []uint64, size int) bitVector {
+	ranks := make([]uint32, 0, len(words)/wordsPerRankBlock+1)
+	ones := 0
+	for idx, word := range words {
+		if idx%wordsPerRankBlock == 0 {
+			ranks = append(ranks, uint32(ones))
+		}
+		ones += bits.OnesCount64(word)
+	}
+	bv := bitVector{words: words, ranks: ranks, size: size}
+	for block := range ranks {
+		blockBits := bitsPerRankBlock
+		if remaining := (len(words) - block*wordsPerRankBlock) * 64; remaining < blockBits {
+			blockBits = remaining
+		}
+		zerosAfter := bv.zerosBefore(block) + blockBits - bv.onesIn(block)
+		for len(bv.zeroBlocks)*zerosPerSelectSample < zerosAfter {
+			bv.zeroBlocks = append(bv.zeroBlocks, uint32(block))
+		}
+	}
+	return bv
+}
+
+func (bv *bitVector) zerosBefore(block int) int {
+	return block*bitsPerRankBlock - int(bv.ranks[block])
+}
+
+func (bv *bitVector) onesIn(block int) int {
+	if block+1 < len(bv.ranks) {
+		return int(bv.ranks[block+1] - bv.ranks[block])
+	}
+	ones := 0
+	for _, word := range bv.words[block*wordsPerRankBlock:] {
+		ones += bits.OnesCount64(word)
+	}
+	return ones
+}
+
+func (bv *bitVector) get(pos int) bool {
+	return bv.words[pos/64]&(1<<uint(pos%64)) != 0
+}
+
+// returns the number of ones before "pos"
+func (bv *bitVector) rank1(pos int) int {
+	word := pos / 64
+	ones := int(bv.ranks[word/wordsPerRankBlock])
+	for idx := word - word%wordsPerRankBlock; idx < word; idx++ {
+		ones += bits.OnesCount64(bv.words[idx])
+	}
+	if offset := uint(pos % 64); offset != 0 {
+		ones += bits.OnesCount64(bv.words[word] << (64 - offset))
+	}
+	return ones
+}
+
+// returns the position of the zero with the given zero-based "rank"
+// the zero must exist
+func (bv *bitVector) select0(rank int) int {
+	// the last block with at most "rank" zeros before it holds the zero
+	sample := rank / zerosPerSelectSample
+	low, high := int(bv.zeroBlocks[sample]), len(bv.ranks)
+	if sample+1 < len(bv.zeroBlocks) {
+		high = int(bv.zeroBlocks[sample+1]) + 1
+	}
+	for high-low > 1 {
+		middle := (low + high) / 2
+		if bv.zerosBefore(middle) <= rank {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	remaining := rank - bv.zerosBefore(low)
+	word := low * wordsPerRankBlock
+	for {
+		zeros := 64 - bits.OnesCount64(bv.words[word])
+		if remaining < zeros {
+			break
+		}
+		remaining -= zeros
+		word++
+	}
+	inverted := ^bv.words[word]
+	for ; remaining > 0; remaining-- {
+		// drops the lowest zero of the word
+		inverted &= inverted - 1
+	}
+	return word*64 + bits.TrailingZeros64(inverted)
+}
+
+// returns the position of the first zero at or after "pos"
+// the zero must exist
+func (bv *bitVector) nextZero(pos int) int {
+	word := pos / 64
+	inverted := ^bv.words[word] >> uint(pos%64) << uint(pos%64)
+	for inverted == 0 {
+		word++
+		inverted = ^bv.words[word]
+	}
+	return word*64 + bits.TrailingZeros64(inverted)
+}
@@ -0,0 +1,43 @@
+package trie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestBitVector_RankSelect(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 63, 64, 65, 511, 512, 513, 5000} {
+		var builder bitVectorBuilder
+		bits := make([]bool, size)
+		for idx := range bits {
+			// long runs of ones make blocks without any zero
+			bits[idx] = idx > size/2 || rnd.Intn(3) > 0
+			builder.push(bits[idx])
+		}
+		bv := builder.build()
+		ones, zeros := 0, 0
+		for idx, bit := range bits {
+			assert.Equal(t, bit, bv.get(idx))
+			assert.Equal(t, ones, bv.rank1(idx), "rank1(%d) of %d bits", idx, size)
+			if bit {
+				ones++
+			} else {
+				assert.Equal(t, idx, bv.select0(zeros), "select0(%d) of %d bits", zeros, size)
+				zeros++
+			}
+		}
+	}
+}
+
+func TestBitVector_NextZero(t *testing.T) {
+	var builder bitVectorBuilder
+	for idx := 0; idx < 300; idx++ {
+		builder.push(idx < 200 || idx%7 != 0)
+	}
+	bv := builder.build()
+	assert.Equal(t, 203, bv.nextZero(0))
+	assert.Equal(t, 203, bv.nextZero(203))
+	assert.Equal(t, 210, bv.nextZero(204))
+}
@@ -0,0 +1,298 @@
+package trie
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// name of the operation reported to watchers when a key is evicted
+const OperationEvict = "Evict"
+
+// what a repository at its capacity does to make room for a new key
+type EvictionPolicy int
+
+const (
+	// evicts the least recently used key, a key is used when it is
+	// inserted, incremented, or read with GetValue or GetMap
+	EvictLRU EvictionPolicy = iota
+	// evicts the key with the lowest value, so the counters
+	// that have been incremented the least go first
+	EvictLFU
+	// evicts nothing, the change is refused with ErrCapacityExceeded
+	RejectNew
+)
+
+// limits of a repository, a limit that is not positive is no limit
+type Capacity struct {
+	// number of raw keys, see Size
+	MaxKeys int
+	// number of nodes of the radix tree, the root included, see Stats
+	// it bounds the memory held by the keys and the pending selectors
+	MaxNodes int
+	Policy   EvictionPolicy
+	// called with every evicted key and its value, after the lock
+	// of the repository is released, so it may use the repository
+	OnEvict func(key string, value int)
+}
+
+// key of a repository, as tracked for eviction
+type capacityEntry struct {
+	node *trieNode
+	key  string
+	// position in the recency list, for EvictLRU
+	element *list.Element
+	// position in the heap, for EvictLFU
+	index int
+}
+
+// min-heap of keys by value, the next key to evict on top
+type valueHeap struct {
+	entries []*capacityEntry
+	// half-life of a decaying repository, whose keys are ordered
+	// by their decayed values, zero otherwise
+	halfLife time.Duration
+}
+
+func (h *valueHeap) Len() int           { return len(h.entries) }
+func (h *valueHeap) Less(i, j int) bool { return h.lower(h.entries[i].node, h.entries[j].node) }
+
+// tells if the value of "a" is lower than the value of "b"
+// decayed values are compared as of the latest update of the two,
+// they decay at the same pace so their order is the same at any later time
+func (h *valueHeap) lower(a, b *trieNode) bool {
+	if h.halfLife <= 0 || a.decay == nil || b.decay == nil {
+		return a.value < b.value
+	}
+	at := a.decay.updated
+	if b.decay.updated.After(at) {
+		at = b.decay.updated
+	}
+	valueA, _ := a.decay.at(at, h.halfLife)
+	valueB, _ := b.decay.at(at, h.halfLife)
+	return valueA < valueB
+}
+
+func (h *valueHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *valueHeap) Push(x interface{}) {
+	entry := x.(*capacityEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *valueHeap) Pop() interface{} {
+	old := h.entries
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	h.entries = old[:len(old)-1]
+	return entry
+}
+
+// orders the keys by their values decayed with "halfLife",
+// or by their values if it is zero
+func (ci *capacityIndex) setHalfLife(halfLife time.Duration) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	ci.byValue.halfLife = halfLife
+	heap.Init(&ci.byValue)
+}
+
+// keys of a repository in eviction order, changed under the lock
+// of the repository and also guarded by its own mutex,
+// since reads move keys in the recency list under the read lock
+type capacityIndex struct {
+	Capacity
+	mutex   sync.Mutex
+	entries map[*trieNode]*capacityEntry
+	// most recently used keys at the front
+	recency *list.List
+	byValue valueHeap
+	// evictions not reported yet
+	evicted []ChangeEvent
+}
+
+func newCapacityIndex(capacity Capacity) *capacityIndex {
+	return &capacityIndex{
+		Capacity: capacity,
+		entries:  make(map[*trieNode]*capacityEntry),
+		recency:  list.New(),
+	}
+}
+
+func (ci *capacityIndex) add(node *trieNode, path string) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	entry := &capacityEntry{node: node, key: path}
+	ci.entries[node] = entry
+	switch ci.Policy {
+	case EvictLRU:
+		entry.element = ci.recency.PushFront(entry)
+	case EvictLFU:
+		heap.Push(&ci.byValue, entry)
+	}
+}
+
+func (ci *capacityIndex) forget(node *trieNode) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	entry, ok := ci.entries[node]
+	if !ok {
+		return
+	}
+	delete(ci.entries, node)
+	switch ci.Policy {
+	case EvictLRU:
+		ci.recency.Remove(entry.element)
+	case EvictLFU:
+		heap.Remove(&ci.byValue, entry.index)
+	}
+}
+
+// forgets every key, the evictions not reported yet are kept
+func (ci *capacityIndex) reset() {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	ci.entries = make(map[*trieNode]*capacityEntry)
+	ci.recency.Init()
+	ci.byValue.entries = nil
+}
+
+// records a use of the key held by "node", after its value may have changed
+func (ci *capacityIndex) touch(node *trieNode) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	entry, ok := ci.entries[node]
+	if !ok {
+		return
+	}
+	switch ci.Policy {
+	case EvictLRU:
+		ci.recency.MoveToFront(entry.element)
+	case EvictLFU:
+		heap.Fix(&ci.byValue, entry.index)
+	}
+}
+
+// returns the next key to evict, never the key held by "protected"
+func (ci *capacityIndex) victim(protected *trieNode) (*capacityEntry, bool) {
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	switch ci.Policy {
+	case EvictLRU:
+		for element := ci.recency.Back(); element != nil; element = element.Prev() {
+			if entry := element.Value.(*capacityEntry); entry.node != protected {
+				return entry, true
+			}
+		}
+	case EvictLFU:
+		// the smallest value after the top is one of its children
+		var best *capacityEntry
+		for idx := 0; idx < 3 && idx < ci.byValue.Len(); idx++ {
+			entry := ci.byValue.entries[idx]
+			if entry.node == protected {
+				continue
+			}
+			if best == nil {
+				best = entry
+				if idx == 0 {
+					break
+				}
+			} else if ci.byValue.lower(entry.node, best.node) {
+				best = entry
+			}
+		}
+		return best, best != nil
+	}
+	return nil, false
+}
+
+// tracks every key below "tn", "path" holds the bytes leading to it
+func (ci *capacityIndex) addAll(tn *trieNode, path []byte) {
+	dfsVisitNodes(tn, path, func(node *trieNode, path []byte) {
+		ci.add(node, string(path))
+	})
+}
+
+// limits the number of keys and nodes of the repository
+// the keys already in the repository are tracked as if they had been
+// inserted in lexicographic order, and evicted right away if they exceed
+// the limits, keys loaded later with Load or decoded are too
+func (t *Repository) AddCapacity(capacity Capacity) *Repository {
+	t.rw.Lock()
+	t.capacity = newCapacityIndex(capacity)
+	t.capacity.byValue.halfLife = t.halfLife
+	t.capacity.addAll(t.root, nil)
+	t.enforceCapacity(nil)
+	t.rw.Unlock()
+	t.reportEvictions()
+	return t
+}
+
+func (t *Repository) overCapacity() bool {
+	c := t.capacity
+	return (c.MaxKeys > 0 && t.size > c.MaxKeys) || (c.MaxNodes > 0 && t.nodes > c.MaxNodes)
+}
+
+// evicts keys until the repository is within its limits or no key is left
+// to evict but the key held by "protected", with RejectNew nothing is evicted
+// must hold the write lock
+func (t *Repository) enforceCapacity(protected *trieNode) {
+	if t.capacity == nil || t.capacity.Policy == RejectNew {
+		return
+	}
+	for t.overCapacity() {
+		entry, ok := t.capacity.victim(protected)
+		if !ok {
+			return
+		}
+		value, _ := t.currentCounts()(entry.node)
+		t.removeKeyLocked(entry.key)
+		t.capacity.mutex.Lock()
+		t.capacity.evicted = append(t.capacity.evicted, ChangeEvent{Key: entry.key, Old: value, Op: OperationEvict})
+		t.capacity.mutex.Unlock()
+	}
+}
+
+// tells if a walk that created "created" nodes, for a new key if "newKey",
+// must be refused by a repository with RejectNew
+// must hold the write lock
+func (t *Repository) rejectsGrowth(created int, newKey bool) bool {
+	c := t.capacity
+	if c == nil || c.Policy != RejectNew {
+		return false
+	}
+	return (newKey && c.MaxKeys > 0 && t.size >= c.MaxKeys) ||
+		(c.MaxNodes > 0 && created > 0 && t.nodes > c.MaxNodes)
+}
+
+// removes the nodes created by a walk along "path" that has been refused
+// must hold the write lock
+func (t *Repository) rollbackWalk(path string) {
+	if nodes := t.root.nodesAlong(path); nodes != nil {
+		t.nodes -= compact(nodes)
+	}
+}
+
+// delivers the evictions to the watchers and to the callback
+// must not be called while holding the lock of the repository
+func (t *Repository) reportEvictions() {
+	if t.capacity == nil {
+		return
+	}
+	t.capacity.mutex.Lock()
+	events := t.capacity.evicted
+	t.capacity.evicted = nil
+	t.capacity.mutex.Unlock()
+	for _, event := range events {
+		t.watchers.publish(event)
+		if t.capacity.OnEvict != nil {
+			t.capacity.OnEvict(event.Key, event.Old)
+		}
+	}
+}
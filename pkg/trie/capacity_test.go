@@ -0,0 +1,151 @@
+package trie
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type evictionRecorder struct {
+	keys   []string
+	values []int
+}
+
+func (r *evictionRecorder) record(key string, value int) {
+	r.keys = append(r.keys, key)
+	r.values = append(r.values, value)
+}
+
+func TestRepository_AddCapacity_EvictLRU(t *testing.T) {
+	recorder := &evictionRecorder{}
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 3, Policy: EvictLRU, OnEvict: recorder.record})
+	_ = repo.Insert("a", 1)
+	_ = repo.Insert("b", 2)
+	_ = repo.Insert("c", 3)
+	// "a" becomes the most recently used key
+	assert.Equal(t, 1, repo.GetValue("a"))
+	assert.NoError(t, repo.Insert("d", 4))
+	assert.Equal(t, 3, repo.Size())
+	assert.False(t, repo.Contains("b"))
+	assert.Equal(t, []string{"b"}, recorder.keys)
+	assert.Equal(t, []int{2}, recorder.values)
+
+	assert.NoError(t, repo.Inc("c"))
+	assert.NoError(t, repo.Insert("e", 5))
+	assert.Equal(t, map[string]int{"c": 4, "d": 4, "e": 5}, repo.GetMap("*"))
+	assert.Equal(t, []string{"b", "a"}, recorder.keys)
+}
+
+func TestRepository_AddCapacity_EvictLFU(t *testing.T) {
+	recorder := &evictionRecorder{}
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 2, Policy: EvictLFU, OnEvict: recorder.record})
+	_ = repo.Insert("a", 0)
+	_ = repo.Insert("b", 0)
+	_ = repo.Inc("a")
+	_ = repo.Inc("a")
+	_ = repo.Inc("b")
+	assert.NoError(t, repo.Insert("c", 0))
+	// the new key is never evicted, even with the lowest value
+	assert.Equal(t, map[string]int{"a": 2, "c": 0}, repo.GetMap("*"))
+	assert.NoError(t, repo.Insert("d", 5))
+	assert.Equal(t, map[string]int{"a": 2, "d": 5}, repo.GetMap("*"))
+	assert.Equal(t, []string{"b", "c"}, recorder.keys)
+}
+
+func TestRepository_AddCapacity_RejectNew(t *testing.T) {
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 2, Policy: RejectNew})
+	assert.NoError(t, repo.Insert("home/a", 1))
+	assert.NoError(t, repo.Insert("home/b", 2))
+	nodes := repo.Stats().Nodes
+	err := repo.Insert("home/c", 3)
+	assert.IsType(t, &ErrCapacityExceeded{}, err)
+	assert.EqualError(t, err, `cannot add "home/c": repository is at its capacity`)
+	// the refused key leaves no node behind
+	assert.Equal(t, nodes, repo.Stats().Nodes)
+	assert.Equal(t, 2, repo.Size())
+	assert.NoError(t, repo.Inc("home/a"))
+	assert.NoError(t, repo.Inc("home/a*"))
+}
+
+func TestRepository_AddCapacity_MaxNodes(t *testing.T) {
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxNodes: 4, Policy: RejectNew})
+	assert.NoError(t, repo.Insert("ab", 1))
+	assert.NoError(t, repo.Insert("ac", 2))
+	assert.Equal(t, 4, repo.Stats().Nodes)
+	// "a" ends on an existing node
+	assert.NoError(t, repo.Insert("a", 3))
+	assert.IsType(t, &ErrCapacityExceeded{}, repo.Insert("abc", 4))
+	assert.Equal(t, 4, repo.Stats().Nodes)
+	assert.NoError(t, repo.Inc("ab*"))
+
+	selectors := buildDefaultTrie().AddCapacity(Capacity{MaxNodes: 2, Policy: RejectNew})
+	assert.NoError(t, selectors.Insert("abc", 1))
+	// the selector would split "abc"
+	assert.IsType(t, &ErrCapacityExceeded{}, selectors.Inc("ab*"))
+	assert.Equal(t, map[string]int{"abc": 1}, selectors.GetMap("*"))
+	assert.Equal(t, 2, selectors.Stats().Nodes)
+
+	evicting := buildDefaultTrie().AddCapacity(Capacity{MaxNodes: 3, Policy: EvictLRU})
+	_ = evicting.Insert("ab", 1)
+	_ = evicting.Insert("ac", 2)
+	// "ab" is pruned, "a" and "c" merge back
+	assert.Equal(t, map[string]int{"ac": 2}, evicting.GetMap("*"))
+	assert.Equal(t, 2, evicting.Stats().Nodes)
+}
+
+func TestRepository_AddCapacity_PrunesEvictedPaths(t *testing.T) {
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 2, Policy: EvictLRU})
+	for _, k := range []string{"user/1/clicks", "user/2/clicks", "user/3/clicks"} {
+		_ = repo.Insert(k, 1)
+	}
+	assert.Equal(t, 2, repo.Size())
+	assert.False(t, repo.Contains("user/1/*"))
+	fresh := buildDefaultTrie()
+	_ = fresh.Insert("user/2/clicks", 1)
+	_ = fresh.Insert("user/3/clicks", 1)
+	assert.Equal(t, fresh.Stats().Nodes, repo.Stats().Nodes)
+	assert.Equal(t, fresh.Stats().Branching, repo.Stats().Branching)
+}
+
+func TestRepository_AddCapacity_ExistingAndLoadedKeys(t *testing.T) {
+	source := buildDefaultTrie()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_ = source.Insert(k, 1)
+	}
+	recorder := &evictionRecorder{}
+	repo := buildDefaultTrie()
+	_ = repo.Insert("x", 1)
+	_ = repo.Insert("y", 1)
+	repo.AddCapacity(Capacity{MaxKeys: 1, OnEvict: recorder.record})
+	assert.Equal(t, []string{"x"}, recorder.keys)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, source.Save(buffer))
+	assert.NoError(t, repo.Load(buffer))
+	assert.Equal(t, 1, repo.Size())
+	assert.Equal(t, []string{"x", "a", "b", "c"}, recorder.keys)
+	assert.Equal(t, 2, repo.Stats().Nodes)
+}
+
+func TestRepository_AddCapacity_ReportsToWatchers(t *testing.T) {
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := repo.Watch(ctx, "a")
+	_ = repo.Insert("a", 7)
+	_ = repo.Insert("b", 1)
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "a", New: 7, Op: OperationInsert}, event)
+	event, _ = nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "a", Old: 7, Op: OperationEvict}, event)
+}
+
+func TestRepository_AddCapacity_Import(t *testing.T) {
+	repo := buildDefaultTrie().AddCapacity(Capacity{MaxKeys: 2, Policy: RejectNew})
+	result, err := repo.ImportJSON(bytes.NewBufferString(`{"a": 1, "b": 2, "c": 3}`), JSONFlat)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+	assert.Len(t, result.Errors, 1)
+	assert.IsType(t, &ErrCapacityExceeded{}, result.Errors[0].Err)
+}
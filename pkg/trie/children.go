@@ -0,0 +1,143 @@
+package trie
+
+// fan-out up to which the children are kept in a sorted slice
+// above it, they move to a table indexed by the first byte of their label
+const maxListedChildren = 32
+
+// children of a node, indexed by the first byte of their labels
+// a node without children costs nothing but the empty struct,
+// small fan-outs live in a slice sorted by first byte and searched
+// with a binary search, large ones in a 256-way table
+// both keep the children ordered, so sorted iteration is free
+type childSet struct {
+	// first bytes of the labels of the listed children, kept apart
+	// so a search does not dereference every child
+	firsts []byte
+	listed []*trieNode
+	table  *childTable
+}
+
+type childTable struct {
+	slots [256]*trieNode
+	size  int
+}
+
+// returns the position of the child starting with "first" in the sorted slice,
+// or the position it would be inserted at
+func (cs *childSet) search(first byte) int {
+	low, high := 0, len(cs.firsts)
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if cs.firsts[middle] < first {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low
+}
+
+func (cs *childSet) get(first byte) (*trieNode, bool) {
+	if cs.table != nil {
+		child := cs.table.slots[first]
+		return child, child != nil
+	}
+	idx := cs.search(first)
+	if idx < len(cs.firsts) && cs.firsts[idx] == first {
+		return cs.listed[idx], true
+	}
+	return nil, false
+}
+
+// adds "child", replacing the child that starts with the same byte, if any
+func (cs *childSet) set(child *trieNode) {
+	first := child.label[0]
+	if cs.table != nil {
+		if cs.table.slots[first] == nil {
+			cs.table.size++
+		}
+		cs.table.slots[first] = child
+		return
+	}
+	idx := cs.search(first)
+	if idx < len(cs.firsts) && cs.firsts[idx] == first {
+		cs.listed[idx] = child
+		return
+	}
+	if len(cs.listed) == maxListedChildren {
+		cs.grow()
+		cs.set(child)
+		return
+	}
+	cs.firsts = append(cs.firsts, 0)
+	copy(cs.firsts[idx+1:], cs.firsts[idx:])
+	cs.firsts[idx] = first
+	cs.listed = append(cs.listed, nil)
+	copy(cs.listed[idx+1:], cs.listed[idx:])
+	cs.listed[idx] = child
+}
+
+// removes the child starting with "first", if any
+// a table that gets small enough shrinks back to a slice
+func (cs *childSet) remove(first byte) {
+	if cs.table != nil {
+		if cs.table.slots[first] != nil {
+			cs.table.slots[first] = nil
+			cs.table.size--
+		}
+		if cs.table.size <= maxListedChildren/2 {
+			cs.shrink()
+		}
+		return
+	}
+	idx := cs.search(first)
+	if idx < len(cs.firsts) && cs.firsts[idx] == first {
+		copy(cs.firsts[idx:], cs.firsts[idx+1:])
+		cs.firsts = cs.firsts[:len(cs.firsts)-1]
+		copy(cs.listed[idx:], cs.listed[idx+1:])
+		cs.listed[len(cs.listed)-1] = nil
+		cs.listed = cs.listed[:len(cs.listed)-1]
+		if len(cs.listed) == 0 {
+			cs.firsts, cs.listed = nil, nil
+		}
+	}
+}
+
+func (cs *childSet) len() int {
+	if cs.table != nil {
+		return cs.table.size
+	}
+	return len(cs.listed)
+}
+
+// returns the children ordered by the first byte of their labels
+// the returned slice must not be modified
+func (cs *childSet) sorted() []*trieNode {
+	if cs.table == nil {
+		return cs.listed
+	}
+	children := make([]*trieNode, 0, cs.table.size)
+	for _, child := range cs.table.slots {
+		if child != nil {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (cs *childSet) grow() {
+	cs.table = &childTable{size: len(cs.listed)}
+	for _, child := range cs.listed {
+		cs.table.slots[child.label[0]] = child
+	}
+	cs.firsts, cs.listed = nil, nil
+}
+
+func (cs *childSet) shrink() {
+	cs.listed = cs.sorted()
+	cs.firsts = make([]byte, 0, len(cs.listed))
+	for _, child := range cs.listed {
+		cs.firsts = append(cs.firsts, child.label[0])
+	}
+	cs.table = nil
+}
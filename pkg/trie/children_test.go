@@ -0,0 +1,92 @@
+package trie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func buildChildSet(firsts ...byte) *childSet {
+	set := &childSet{}
+	for _, first := range firsts {
+		set.set(newTrieNode(string([]byte{first, 'x'})))
+	}
+	return set
+}
+
+func firstBytes(children []*trieNode) []byte {
+	firsts := make([]byte, 0, len(children))
+	for _, child := range children {
+		firsts = append(firsts, child.label[0])
+	}
+	return firsts
+}
+
+func Test_childSet_EmptyCostsNoAllocation(t *testing.T) {
+	set := &childSet{}
+	assert.Equal(t, 0, set.len())
+	assert.Nil(t, set.firsts)
+	assert.Nil(t, set.listed)
+	assert.Nil(t, set.table)
+	_, hasChild := set.get('a')
+	assert.False(t, hasChild)
+}
+
+func Test_childSet_set_KeepsSorted(t *testing.T) {
+	set := buildChildSet('c', 'a', 'b', 'a')
+	assert.Equal(t, []byte{'a', 'b', 'c'}, firstBytes(set.sorted()))
+	assert.Equal(t, 3, set.len())
+}
+
+func Test_childSet_set_Replaces(t *testing.T) {
+	set := buildChildSet('a')
+	replacement := newTrieNode("ay")
+	set.set(replacement)
+	child, hasChild := set.get('a')
+	assert.True(t, hasChild)
+	assert.Equal(t, replacement, child)
+	assert.Equal(t, 1, set.len())
+}
+
+func Test_childSet_GrowsIntoTable(t *testing.T) {
+	set := &childSet{}
+	expected := make([]byte, 0)
+	for first := 255; first >= 0; first -= 3 {
+		set.set(newTrieNode(string([]byte{byte(first)})))
+		expected = append([]byte{byte(first)}, expected...)
+	}
+	assert.NotNil(t, set.table)
+	assert.Nil(t, set.listed)
+	assert.Equal(t, len(expected), set.len())
+	assert.Equal(t, expected, firstBytes(set.sorted()))
+	for _, first := range expected {
+		child, hasChild := set.get(first)
+		assert.True(t, hasChild)
+		assert.Equal(t, first, child.label[0])
+	}
+}
+
+func Test_childSet_remove(t *testing.T) {
+	set := buildChildSet('a', 'b', 'c')
+	set.remove('b')
+	set.remove('z')
+	assert.Equal(t, []byte{'a', 'c'}, firstBytes(set.sorted()))
+	set.remove('a')
+	set.remove('c')
+	assert.Equal(t, 0, set.len())
+	assert.Nil(t, set.listed)
+}
+
+func Test_childSet_remove_ShrinksTable(t *testing.T) {
+	set := &childSet{}
+	for first := 0; first <= maxListedChildren; first++ {
+		set.set(newTrieNode(string([]byte{byte(first)})))
+	}
+	assert.NotNil(t, set.table)
+	for first := 0; first <= maxListedChildren/2; first++ {
+		set.remove(byte(first))
+	}
+	assert.Nil(t, set.table)
+	assert.Equal(t, maxListedChildren/2, set.len())
+	_, hasChild := set.get(maxListedChildren)
+	assert.True(t, hasChild)
+}
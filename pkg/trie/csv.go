@@ -0,0 +1,85 @@
+package trie
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/intenvy/memoir/pkg/key"
+	"io"
+	"strconv"
+)
+
+var csvHeader = []string{"key", "value"}
+
+type CSVOptions struct {
+	// the first row is a header and is not imported
+	HasHeader bool
+	// what to do with keys that already exist
+	Duplicates DuplicatePolicy
+	// the import stops once that many errors have been collected
+	// zero means the import never stops on entry errors
+	MaxErrors int
+}
+
+// streams the keys that match "pattern" to "w" as "key,value" rows in lexicographic order
+// pending selector increments are written as keys ending with the selector
+func (t *Repository) ExportCSV(w io.Writer, pattern string, withHeader bool) error {
+	entry := key.New(pattern, t.converter, t.validator)
+	out := csv.NewWriter(w)
+	if withHeader {
+		if err := out.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	var err error
+	t.expireDue()
+	t.rw.RLock()
+	t.visitMatches(entry, func(path []byte, value int) {
+		if err == nil {
+			err = out.Write([]string{string(path), strconv.Itoa(value)})
+		}
+	})
+	t.rw.RUnlock()
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// bulk loads "key,value" rows from "r" through the converter and the validator
+// rows that are malformed or cannot be imported are collected in the result,
+// by record, or by line when the CSV itself cannot be parsed
+// the error is only returned when "r" fails
+func (t *Repository) ImportCSV(r io.Reader, options CSVOptions) (*ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	result := &ImportResult{}
+	for row := 1; options.MaxErrors == 0 || len(result.Errors) < options.MaxErrors; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if parseErr, isParseErr := err.(*csv.ParseError); isParseErr {
+			result.record(parseErr.Line, "", parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if row == 1 && options.HasHeader {
+			continue
+		}
+		if len(record) != len(csvHeader) {
+			result.recordRow(row, "", fmt.Errorf("row must have %d fields, got: %d", len(csvHeader), len(record)))
+			continue
+		}
+		value, err := strconv.Atoi(record[1])
+		if err != nil {
+			result.recordRow(row, record[0], fmt.Errorf("value must be an integer, got: %s", record[1]))
+			continue
+		}
+		result.recordRow(row, record[0], t.importEntry(record[0], value, options.Duplicates))
+	}
+	return result, nil
+}
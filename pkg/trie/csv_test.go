@@ -0,0 +1,82 @@
+package trie
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRepository_ExportCSV(t *testing.T) {
+	repo := buildTrieFromTokens(1, "home/bin/", "home/", "root/etc")
+	_ = repo.Inc("home/bin/*")
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportCSV(buffer, "home/*", true))
+	expected := "key,value\nhome/,1\nhome/bin/,1\nhome/bin/*,1\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestRepository_ImportCSV_RoundTrip(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportCSV(buffer, "*", true))
+	imported := buildDefaultTrie()
+	result, err := imported.ImportCSV(buffer, CSVOptions{HasHeader: true})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, Diff(repo, imported, "*"))
+	assert.Equal(t, repo.Size(), imported.Size())
+}
+
+func TestRepository_ImportCSV_DuplicatePolicies(t *testing.T) {
+	rows := "a,1\nb,2\na,3\n"
+	cases := map[DuplicatePolicy]int{
+		DuplicateError:     1,
+		DuplicateSkip:      1,
+		DuplicateOverwrite: 3,
+		DuplicateSum:       4,
+	}
+	for policy, expected := range cases {
+		repo := buildDefaultTrie()
+		result, err := repo.ImportCSV(strings.NewReader(rows), CSVOptions{Duplicates: policy})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, repo.GetValue("a"), policy)
+		assert.Equal(t, 2, repo.Size())
+		if policy == DuplicateError {
+			assert.Len(t, result.Errors, 1)
+		}
+		if policy == DuplicateSkip {
+			assert.Equal(t, 1, result.Skipped)
+		}
+	}
+}
+
+func TestRepository_ImportCSV_CollectsErrors(t *testing.T) {
+	rows := "key,value\na,1\nb\nc,x\nd e,4\nf,6\n"
+	repo := buildDefaultTrie()
+	result, err := repo.ImportCSV(strings.NewReader(rows), CSVOptions{HasHeader: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+	assert.Len(t, result.Errors, 3)
+	assert.Equal(t, 3, result.Errors[0].Record)
+	assert.Equal(t, "c", result.Errors[1].Key)
+}
+
+func TestRepository_ImportCSV_MultiLineRecords(t *testing.T) {
+	rows := "\"a\nb\",1\nc,x\n"
+	repo := buildDefaultTrie()
+	result, err := repo.ImportCSV(strings.NewReader(rows), CSVOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Errors, 1)
+	// the second record starts on the third line
+	assert.EqualError(t, result.Errors[0], `record 2: key: "c": value must be an integer, got: x`)
+}
+
+func TestRepository_ImportCSV_MaxErrors(t *testing.T) {
+	rows := "a\nb\nc,1\n"
+	repo := buildDefaultTrie()
+	result, err := repo.ImportCSV(strings.NewReader(rows), CSVOptions{MaxErrors: 2})
+	assert.NoError(t, err)
+	assert.Len(t, result.Errors, 2)
+	assert.False(t, repo.Contains("c"))
+}
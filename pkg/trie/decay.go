@@ -0,0 +1,105 @@
+package trie
+
+import (
+	"math"
+	"time"
+)
+
+// exact counts of a node of a decaying repository, as of "updated"
+// the integer value and selector increment of the node are these
+// counts rounded, as of the last time the node changed
+type decayedCounts struct {
+	value    float64
+	selector float64
+	updated  time.Time
+}
+
+// makes the values and the pending selector increments decay,
+// halving every "halfLife", so they reflect the recent increments
+// rather than all of them, e.g. to find the keys trending now
+// every read returns the values decayed up to now, the snapshots and
+// the exports included, so a repository loaded from a snapshot
+// starts to decay from the values it had when it was saved
+func (t *Repository) AddDecay(halfLife time.Duration) *Repository {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	t.halfLife = halfLife
+	resetDecay(t.root, t.clock.Now())
+	if t.capacity != nil {
+		t.capacity.setHalfLife(halfLife)
+	}
+	return t
+}
+
+// starts the decay of the counts of every node below "tn" at "now"
+func resetDecay(tn *trieNode, now time.Time) {
+	tn.decay = &decayedCounts{value: float64(tn.value), selector: float64(tn.selector), updated: now}
+	for _, child := range tn.sortedChildren() {
+		resetDecay(child, now)
+	}
+}
+
+// returns the counts decayed up to "now", halving every "halfLife"
+func (dc *decayedCounts) at(now time.Time, halfLife time.Duration) (value, selector float64) {
+	factor := 1.0
+	if elapsed := now.Sub(dc.updated); elapsed > 0 {
+		factor = math.Exp2(-float64(elapsed) / float64(halfLife))
+	}
+	return dc.value * factor, dc.selector * factor
+}
+
+// returns the counts of "tn" decayed up to "now"
+func (t *Repository) decayedCounts(tn *trieNode, now time.Time) (value, selector float64) {
+	if tn.decay == nil {
+		return float64(tn.value), float64(tn.selector)
+	}
+	return tn.decay.at(now, t.halfLife)
+}
+
+// decays the counts of "tn" up to now and adds "delta" to its value,
+// or to its pending selector increment if "selector"
+// returns the rounded count before the increment
+// must hold the write lock
+func (t *Repository) decayedInc(tn *trieNode, selector bool, delta int) int {
+	now := t.clock.Now()
+	value, selectorValue := t.decayedCounts(tn, now)
+	tn.decay = &decayedCounts{value: value, selector: selectorValue, updated: now}
+	counted := &tn.decay.value
+	if selector {
+		counted = &tn.decay.selector
+	}
+	old := int(math.Round(*counted))
+	*counted += float64(delta)
+	tn.value = int(math.Round(tn.decay.value))
+	tn.selector = int(math.Round(tn.decay.selector))
+	return old
+}
+
+// decays the counts of "tn" up to now after its value, or its pending
+// selector increment if "selector", has been set, so the count set
+// starts to decay now
+// must hold the write lock
+func (t *Repository) setDecayed(tn *trieNode, selector bool) {
+	now := t.clock.Now()
+	value, selectorValue := t.decayedCounts(tn, now)
+	if selector {
+		selectorValue = float64(tn.selector)
+	} else {
+		value = float64(tn.value)
+	}
+	tn.decay = &decayedCounts{value: value, selector: selectorValue, updated: now}
+}
+
+// returns how the reads count the values of the nodes,
+// decayed up to now on a decaying repository
+// must hold the lock
+func (t *Repository) currentCounts() nodeCounts {
+	if t.halfLife <= 0 {
+		return storedCounts
+	}
+	now := t.clock.Now()
+	return func(tn *trieNode) (int, int) {
+		value, selector := t.decayedCounts(tn, now)
+		return int(math.Round(value)), int(math.Round(selector))
+	}
+}
@@ -0,0 +1,148 @@
+package trie
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRepository_AddDecay(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Hour)
+	_ = repo.Insert("posts/a", 0)
+	_ = repo.Insert("posts/b", 0)
+	assert.NoError(t, repo.IncBy("posts/a", 100))
+	manual.Advance(time.Hour)
+	assert.Equal(t, 50, repo.GetValue("posts/a"))
+	assert.NoError(t, repo.IncBy("posts/b", 60))
+	// "b" is trending now, although "a" has more increments in all
+	assert.Equal(t, map[string]int{"posts/a": 50, "posts/b": 60}, repo.GetMap("posts/*"))
+	assert.Equal(t, 110, repo.GetValue("posts/*"))
+
+	manual.Advance(2 * time.Hour)
+	assert.Equal(t, map[string]int{"posts/a": 13, "posts/b": 15}, repo.GetMap("*"))
+	// an increment adds to the decayed value
+	assert.NoError(t, repo.Inc("posts/a"))
+	assert.Equal(t, 14, repo.GetValue("posts/a"))
+	assert.Equal(t, 29, repo.GetValue("posts/*"))
+}
+
+func TestRepository_AddDecay_Selectors(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute)
+	_ = repo.Insert("a/x", 0)
+	_ = repo.Insert("a/y", 0)
+	assert.NoError(t, repo.IncBy("a/x", 8))
+	assert.NoError(t, repo.IncBy("a/x*", 4))
+	assert.Equal(t, 12, repo.GetValue("a/x"))
+	manual.Advance(2 * time.Minute)
+	assert.Equal(t, 3, repo.GetValue("a/x"))
+	assert.Equal(t, map[string]int{"a/x": 2, "a/x*": 1, "a/y": 0}, repo.GetMap("a/*"))
+}
+
+func TestRepository_AddDecay_ExistingAndLoadedValues(t *testing.T) {
+	repo, manual := buildClockTrie()
+	_ = repo.Insert("a", 40)
+	repo.AddDecay(time.Minute)
+	manual.Advance(time.Minute)
+	assert.Equal(t, 20, repo.GetValue("a"))
+
+	source := buildDefaultTrie()
+	_ = source.Insert("b", 64)
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, source.Save(buffer))
+	assert.NoError(t, repo.Load(buffer))
+	manual.Advance(3 * time.Minute)
+	assert.Equal(t, 8, repo.GetValue("b"))
+
+	// summed with the value decayed up to now
+	result, err := repo.ImportCSV(bytes.NewBufferString("b,2\n"), CSVOptions{Duplicates: DuplicateSum})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, 10, repo.GetValue("b"))
+}
+
+func TestRepository_AddDecay_Quota(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute)
+	_ = repo.Insert("p/a", 0)
+	assert.NoError(t, repo.SetLimit("p/*", 100))
+	assert.NoError(t, repo.IncBy("p/a", 100))
+	assert.IsType(t, &ErrQuotaExceeded{}, repo.Inc("p/a"))
+	// the limit applies to the sum decayed up to now
+	manual.Advance(time.Hour)
+	assert.Equal(t, 0, repo.GetValue("p/*"))
+	assert.NoError(t, repo.Inc("p/a"))
+}
+
+func TestRepository_AddDecay_CapacityLRU(t *testing.T) {
+	repo, _ := buildClockTrie()
+	repo.AddDecay(time.Minute).AddCapacity(Capacity{MaxKeys: 2, Policy: EvictLRU})
+	_ = repo.Insert("a", 1)
+	_ = repo.Insert("b", 1)
+	// reads are uses of the keys on a decaying repository too
+	repo.GetValue("a")
+	_ = repo.Insert("c", 1)
+	assert.True(t, repo.Contains("a"))
+	assert.False(t, repo.Contains("b"))
+	repo.GetMap("a")
+	_ = repo.Insert("d", 1)
+	assert.True(t, repo.Contains("a"))
+	assert.False(t, repo.Contains("c"))
+}
+
+func TestRepository_AddDecay_SaveLoad(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute)
+	_ = repo.Insert("a", 0)
+	assert.NoError(t, repo.IncBy("a", 1000))
+	manual.Advance(10 * time.Minute)
+	assert.Equal(t, 1, repo.GetValue("a"))
+
+	// saved as decayed up to now
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.Save(buffer))
+	assert.NoError(t, repo.Load(buffer))
+	assert.Equal(t, 1, repo.GetValue("a"))
+	loaded := buildDefaultTrie()
+	assert.NoError(t, repo.Save(buffer))
+	assert.NoError(t, loaded.Load(buffer))
+	assert.Equal(t, 1, loaded.GetValue("a"))
+}
+
+func TestRepository_AddDecay_Reads(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute)
+	_ = repo.Insert("a", 0)
+	_ = repo.Insert("ab", 0)
+	assert.NoError(t, repo.IncBy("a", 1000))
+	assert.NoError(t, repo.IncBy("ab", 64))
+	manual.Advance(2 * time.Minute)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportCSV(buffer, "*", false))
+	assert.Equal(t, "a,250\nab,16\n", buffer.String())
+	_, value, _ := repo.LongestPrefixOf("abc")
+	assert.Equal(t, 16, value)
+	assert.Equal(t, 250, repo.Freeze().GetValue("a"))
+
+	other := buildDefaultTrie()
+	_ = other.Insert("a", 250)
+	_ = other.Insert("ab", 10)
+	assert.Equal(t, []DiffEntry{{Key: "ab", Kind: DiffChanged, Old: 16, New: 10}}, Diff(repo, other, "*"))
+}
+
+func TestRepository_AddDecay_CapacityLFU(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute).AddCapacity(Capacity{MaxKeys: 2, Policy: EvictLFU})
+	_ = repo.Insert("old", 0)
+	assert.NoError(t, repo.IncBy("old", 100))
+	manual.Advance(10 * time.Minute)
+	_ = repo.Insert("new", 0)
+	assert.NoError(t, repo.IncBy("new", 5))
+	// "old" has decayed below "new"
+	_ = repo.Insert("newer", 1)
+	assert.False(t, repo.Contains("old"))
+	assert.True(t, repo.Contains("new"))
+}
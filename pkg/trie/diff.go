@@ -0,0 +1,229 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"unsafe"
+)
+
+// kind of difference between two repositories for a single key
+type DiffKind int
+
+const (
+	// key exists only in the second repository
+	DiffAdded DiffKind = iota
+	// key exists only in the first repository
+	DiffRemoved
+	// key exists in both repositories with different values
+	DiffChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// a single difference reported by Diff
+// Old is zero for added keys and New is zero for removed keys
+type DiffEntry struct {
+	Key  string
+	Kind DiffKind
+	Old  int
+	New  int
+}
+
+// returns the differences between the keys of "a" and "b"
+// that match the given "pattern", ordered by key
+// pending selector increments are compared like keys, e.g. "home/*"
+// the pattern is converted and validated with the converter
+// and the validator of "a"
+func Diff(a, b *Repository, pattern string) []DiffEntry {
+	entry := key.New(pattern, a.converter, a.validator)
+	diffs := make([]DiffEntry, 0)
+	if a == b {
+		return diffs
+	}
+	a.expireDue()
+	b.expireDue()
+	rlockInOrder(a, b)
+	defer a.rw.RUnlock()
+	defer b.rw.RUnlock()
+
+	path := entryPath(entry)
+	pointA := diffStart(a, path)
+	pointB := diffStart(b, path)
+	if entry.IsSelector() {
+		dfsDiff(pointA, pointB, []byte(path), &diffs)
+		return diffs
+	}
+	// a raw pattern only compares the key itself
+	valueA, inA := pointA.key()
+	valueB, inB := pointB.key()
+	diffValues(path, valueA, inA, valueB, inB, &diffs)
+	return diffs
+}
+
+// read-locks both repositories in the order of their addresses, so two
+// calls locking the same repositories cannot wait on each other
+// while writers are waiting for both
+func rlockInOrder(a, b *Repository) {
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		a, b = b, a
+	}
+	a.rw.RLock()
+	b.rw.RLock()
+}
+
+// a point of the trie that lies "rest" bytes above "node"
+// on the label leading to it, or on "node" itself when "rest" is empty
+// the trie seen through points has one point per byte of the keys,
+// so tries of different shapes can be walked in lockstep
+type diffPoint struct {
+	node *trieNode
+	rest string
+	// reads the values of the nodes
+	counts nodeCounts
+}
+
+// returns the point at "path" or nil if "path" is not present
+func diffStart(t *Repository, path string) *diffPoint {
+	node, rest, completeWalk := t.root.lazyWalk(path)
+	if !completeWalk {
+		return nil
+	}
+	return &diffPoint{node: node, rest: rest, counts: t.currentCounts()}
+}
+
+func (p *diffPoint) key() (int, bool) {
+	if p == nil || p.rest != "" || !p.node.endOfKey {
+		return 0, false
+	}
+	value, _ := p.counts(p.node)
+	return value, true
+}
+
+func (p *diffPoint) selector() (int, bool) {
+	if p == nil || p.rest != "" || !p.node.hasSelector {
+		return 0, false
+	}
+	_, selector := p.counts(p.node)
+	return selector, true
+}
+
+type diffChild struct {
+	symbol byte
+	point  *diffPoint
+}
+
+// returns the points one byte below, ordered by that byte
+func (p *diffPoint) children() []diffChild {
+	if p == nil {
+		return nil
+	}
+	if p.rest != "" {
+		return []diffChild{{symbol: p.rest[0], point: &diffPoint{node: p.node, rest: p.rest[1:], counts: p.counts}}}
+	}
+	children := make([]diffChild, 0, p.node.noOfChildren())
+	for _, child := range p.node.sortedChildren() {
+		children = append(children, diffChild{
+			symbol: child.label[0],
+			point:  &diffPoint{node: child, rest: child.label[1:], counts: p.counts},
+		})
+	}
+	return children
+}
+
+// appends the difference between two values of the same key, if any
+func diffValues(path string, a int, inA bool, b int, inB bool, out *[]DiffEntry) {
+	switch {
+	case inA && inB && a != b:
+		*out = append(*out, DiffEntry{Key: path, Kind: DiffChanged, Old: a, New: b})
+	case inA && !inB:
+		*out = append(*out, DiffEntry{Key: path, Kind: DiffRemoved, Old: a})
+	case !inA && inB:
+		*out = append(*out, DiffEntry{Key: path, Kind: DiffAdded, New: b})
+	}
+}
+
+// reports every key below a point that is present on one side only
+func diffOneSided(p *diffPoint, path []byte, kind DiffKind, out *[]DiffEntry) {
+	dfsVisitKeys(p.node, append(path, p.rest...), p.counts, func(path []byte, value int) {
+		if kind == DiffAdded {
+			*out = append(*out, DiffEntry{Key: string(path), Kind: kind, New: value})
+		} else {
+			*out = append(*out, DiffEntry{Key: string(path), Kind: kind, Old: value})
+		}
+	})
+}
+
+// walks both sub-tries in lockstep, merging their sorted children
+// a sub-trie that is present on one side only is reported as a whole
+// without comparing
+func dfsDiff(a, b *diffPoint, path []byte, out *[]DiffEntry) {
+	switch {
+	case a == nil && b == nil:
+		return
+	case a == nil:
+		diffOneSided(b, path, DiffAdded, out)
+		return
+	case b == nil:
+		diffOneSided(a, path, DiffRemoved, out)
+		return
+	}
+	valueA, inA := a.key()
+	valueB, inB := b.key()
+	diffValues(string(path), valueA, inA, valueB, inB, out)
+
+	selectorA, hasSelectorA := a.selector()
+	selectorB, hasSelectorB := b.selector()
+	selectorDone := !hasSelectorA && !hasSelectorB
+	diffSelector := func() {
+		diffValues(string(append(path, key.SelectorChar)), selectorA, hasSelectorA, selectorB, hasSelectorB, out)
+		selectorDone = true
+	}
+
+	childrenA, childrenB := a.children(), b.children()
+	i, j := 0, 0
+	for i < len(childrenA) || j < len(childrenB) {
+		var childA, childB *diffPoint
+		var symbol byte
+		switch {
+		case j == len(childrenB) || (i < len(childrenA) && childrenA[i].symbol < childrenB[j].symbol):
+			childA, symbol = childrenA[i].point, childrenA[i].symbol
+			i++
+		case i == len(childrenA) || childrenB[j].symbol < childrenA[i].symbol:
+			childB, symbol = childrenB[j].point, childrenB[j].symbol
+			j++
+		default:
+			childA, childB, symbol = childrenA[i].point, childrenB[j].point, childrenA[i].symbol
+			i++
+			j++
+		}
+		if !selectorDone && symbol >= key.SelectorChar {
+			diffSelector()
+		}
+		dfsDiff(childA, childB, append(path, symbol), out)
+	}
+	if !selectorDone {
+		diffSelector()
+	}
+}
+
+// returns the changes of the keys from the tree "from", whose values
+// are read by "counts", to the tree "to", as reported to watchers
+// by the operation "op"
+func changesBetween(from *trieNode, counts nodeCounts, to *trieNode, op string) []ChangeEvent {
+	diffs := make([]DiffEntry, 0)
+	dfsDiff(&diffPoint{node: from, counts: counts}, &diffPoint{node: to, counts: storedCounts}, nil, &diffs)
+	events := make([]ChangeEvent, 0, len(diffs))
+	for _, diff := range diffs {
+		events = append(events, ChangeEvent{Key: diff.Key, Old: diff.Old, New: diff.New, Op: op})
+	}
+	return events
+}
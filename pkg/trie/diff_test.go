@@ -0,0 +1,78 @@
+package trie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiff_AllSelector(t *testing.T) {
+	a := buildTrieFromTokens(1, "t1", "t2", "t3", "u1")
+	b := buildTrieFromTokens(1, "t1", "t3", "t4", "u1")
+	_ = b.Inc("t3")
+	_ = b.Inc("t*")
+	expected := []DiffEntry{
+		{Key: "t*", Kind: DiffAdded, New: 1},
+		{Key: "t2", Kind: DiffRemoved, Old: 1},
+		{Key: "t3", Kind: DiffChanged, Old: 1, New: 2},
+		{Key: "t4", Kind: DiffAdded, New: 1},
+	}
+	actual := Diff(a, b, "*")
+	assert.Equal(t, expected, actual)
+}
+
+func TestDiff_SubTrieSelector(t *testing.T) {
+	a := buildTrieFromTokens(1, "home/a", "home/b", "root/a")
+	b := buildTrieFromTokens(1, "home/a", "root/b")
+	expected := []DiffEntry{
+		{Key: "root/a", Kind: DiffRemoved, Old: 1},
+		{Key: "root/b", Kind: DiffAdded, New: 1},
+	}
+	actual := Diff(a, b, "root/*")
+	assert.Equal(t, expected, actual)
+}
+
+func TestDiff_StrictKey(t *testing.T) {
+	a := buildTrieFromTokens(1, "abc", "abcd")
+	b := buildTrieFromTokens(2, "abc")
+	expected := []DiffEntry{{Key: "abc", Kind: DiffChanged, Old: 1, New: 2}}
+	actual := Diff(a, b, "abc")
+	assert.Equal(t, expected, actual)
+}
+
+func TestDiff_MissingPath(t *testing.T) {
+	a := buildTrieFromTokens(1, "abc")
+	b := buildTrieFromTokens(1, "abd")
+	assert.Empty(t, Diff(a, b, "zzz*"))
+}
+
+func TestDiff_IdenticalRepositories(t *testing.T) {
+	a := buildTrieFromTokens(1, "abc", "abd", "b")
+	b := buildTrieFromTokens(1, "b", "abd", "abc")
+	assert.Empty(t, Diff(a, b, "*"))
+	assert.Empty(t, Diff(a, a, "*"))
+}
+
+func TestDiff_ConcurrentOppositeOrders(t *testing.T) {
+	a := buildTrieFromTokens(1, "t1", "t2")
+	b := buildTrieFromTokens(1, "t1", "t3")
+	var group sync.WaitGroup
+	for idx := 0; idx < 4; idx++ {
+		group.Add(4)
+		go func() { defer group.Done(); Diff(a, b, "*") }()
+		go func() { defer group.Done(); Diff(b, a, "*") }()
+		go func() { defer group.Done(); _ = a.Inc("t1") }()
+		go func() { defer group.Done(); _ = b.Inc("t1") }()
+	}
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Diff deadlocked")
+	}
+}
@@ -0,0 +1,84 @@
+package trie
+
+import "fmt"
+
+// error that is returned when a snapshot
+// cannot be decoded into a repository
+type ErrCorruptSnapshot struct {
+	reason string
+}
+
+var _ error = (*ErrCorruptSnapshot)(nil)
+
+func NewErrCorruptSnapshot(reason string) *ErrCorruptSnapshot {
+	return &ErrCorruptSnapshot{reason: reason}
+}
+
+func (e *ErrCorruptSnapshot) Error() string {
+	return fmt.Sprintf("corrupt snapshot: %s", e.reason)
+}
+
+// error that is returned when a read-only
+// repository is asked to change
+type ErrReadOnly struct {
+	pattern string
+}
+
+var _ error = (*ErrReadOnly)(nil)
+
+func NewErrReadOnly(pattern string) *ErrReadOnly {
+	return &ErrReadOnly{pattern: pattern}
+}
+
+func (e *ErrReadOnly) Error() string {
+	return fmt.Sprintf(`cannot modify "%s": repository is read-only`, e.pattern)
+}
+
+// error that is returned when a change would take
+// a repository beyond its capacity and its policy is RejectNew
+type ErrCapacityExceeded struct {
+	pattern string
+}
+
+var _ error = (*ErrCapacityExceeded)(nil)
+
+func NewErrCapacityExceeded(pattern string) *ErrCapacityExceeded {
+	return &ErrCapacityExceeded{pattern: pattern}
+}
+
+func (e *ErrCapacityExceeded) Error() string {
+	return fmt.Sprintf(`cannot add "%s": repository is at its capacity`, e.pattern)
+}
+
+// error that is returned when an increment would take the keys
+// of a prefix beyond the limit set with SetLimit
+type ErrQuotaExceeded struct {
+	// selector of the prefix whose limit would be exceeded, e.g. "tenants/acme/*"
+	Prefix string
+	Limit  int
+}
+
+var _ error = (*ErrQuotaExceeded)(nil)
+
+func NewErrQuotaExceeded(prefix string, limit int) *ErrQuotaExceeded {
+	return &ErrQuotaExceeded{Prefix: prefix, Limit: limit}
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf(`quota of "%s" exceeded: limit is %d`, e.Prefix, e.Limit)
+}
+
+// error that is returned when a selector is expected
+type ErrNotSelector struct {
+	pattern string
+}
+
+var _ error = (*ErrNotSelector)(nil)
+
+func NewErrNotSelector(pattern string) *ErrNotSelector {
+	return &ErrNotSelector{pattern: pattern}
+}
+
+func (e *ErrNotSelector) Error() string {
+	return fmt.Sprintf(`"%s" is not a selector`, e.pattern)
+}
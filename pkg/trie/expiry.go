@@ -0,0 +1,268 @@
+package trie
+
+import (
+	"container/heap"
+	"context"
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/key"
+	"sync/atomic"
+	"time"
+)
+
+// name of the operation reported to watchers when a key expires
+const OperationExpire = "Expire"
+
+// deadline of a key with a time to live
+type expiryEntry struct {
+	node     *trieNode
+	key      string
+	deadline time.Time
+	// position in the heap
+	index int
+}
+
+// min-heap of deadlines, the next key to expire on top
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	entry := x.(*expiryEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// deadlines of the keys of a repository, guarded by its lock
+// but for "next" which is read without it, to tell cheaply
+// if any key is due before taking the lock
+type expiryIndex struct {
+	// unix nanoseconds of the earliest deadline, zero if there is none
+	// first field, so it is aligned for atomic access
+	next    int64
+	heap    expiryHeap
+	entries map[*trieNode]*expiryEntry
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{entries: make(map[*trieNode]*expiryEntry)}
+}
+
+func (ei *expiryIndex) updateNext() {
+	next := int64(0)
+	if len(ei.heap) > 0 {
+		next = ei.heap[0].deadline.UnixNano()
+	}
+	atomic.StoreInt64(&ei.next, next)
+}
+
+func (ei *expiryIndex) set(node *trieNode, path string, deadline time.Time) {
+	if entry, ok := ei.entries[node]; ok {
+		entry.deadline = deadline
+		heap.Fix(&ei.heap, entry.index)
+	} else {
+		entry = &expiryEntry{node: node, key: path, deadline: deadline}
+		ei.entries[node] = entry
+		heap.Push(&ei.heap, entry)
+	}
+	ei.updateNext()
+}
+
+func (ei *expiryIndex) clear(node *trieNode) {
+	if entry, ok := ei.entries[node]; ok {
+		heap.Remove(&ei.heap, entry.index)
+		delete(ei.entries, node)
+		ei.updateNext()
+	}
+}
+
+// returns the deadlines by key
+func (ei *expiryIndex) deadlines() map[string]time.Time {
+	deadlines := make(map[string]time.Time, len(ei.heap))
+	for _, entry := range ei.heap {
+		deadlines[entry.key] = entry.deadline
+	}
+	return deadlines
+}
+
+func (ei *expiryIndex) reset() {
+	ei.heap = nil
+	ei.entries = make(map[*trieNode]*expiryEntry)
+	ei.updateNext()
+}
+
+// sets the clock used for the deadlines of the keys
+func (t *Repository) AddClock(c clock.Clock) *Repository {
+	t.clock = c
+	return t
+}
+
+// returns the clock used for the deadlines, decay and time series
+func (t *Repository) Clock() clock.Clock {
+	return t.clock
+}
+
+// same as Insert, the key is removed once "ttl" has passed
+func (t *Repository) InsertWithTTL(pattern string, value int, ttl time.Duration) error {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	if entry.IsSelector() {
+		return key.NewErrSelectorKeyNotAllowed(*entry)
+	}
+	err := t.insertEntry(entry, value, ttl)
+	if err == nil {
+		t.watchers.publish(ChangeEvent{Key: string(*entry), New: value, Op: OperationInsert})
+	}
+	t.reportEvictions()
+	return err
+}
+
+// sets the time to live of the key "pattern", counted from now
+// a "ttl" that is not positive removes it, so the key never expires
+// returns an error if the key does not exist
+func (t *Repository) SetTTL(pattern string, ttl time.Duration) error {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	if entry.IsSelector() {
+		return key.NewErrSelectorKeyNotAllowed(*entry)
+	}
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if !completeWalk || rest != "" || !node.endOfKey {
+		return key.NewErrKeyNotFound(*entry)
+	}
+	t.setTTL(node, string(*entry), ttl)
+	return nil
+}
+
+// must hold the write lock
+func (t *Repository) setTTL(node *trieNode, path string, ttl time.Duration) {
+	if ttl > 0 {
+		t.expiries.set(node, path, t.clock.Now().Add(ttl))
+	} else {
+		t.expiries.clear(node)
+	}
+}
+
+// returns the time left before the key "pattern" expires
+// and false if the key does not exist or never expires
+func (t *Repository) TTL(pattern string) (time.Duration, bool) {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if !completeWalk || rest != "" || !node.endOfKey {
+		return 0, false
+	}
+	expiry, ok := t.expiries.entries[node]
+	if !ok {
+		return 0, false
+	}
+	return expiry.deadline.Sub(t.clock.Now()), true
+}
+
+// sets the time to live of every key matching "pattern", counted from now
+// a "ttl" that is not positive expires the keys at once
+// returns the number of keys matching "pattern"
+func (t *Repository) Expire(pattern string, ttl time.Duration) int {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.Lock()
+	matched := 0
+	deadline := t.clock.Now().Add(ttl)
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if completeWalk && entry.IsSelector() {
+		// the prefix may end inside the label of node
+		dfsVisitNodes(node, []byte(entryPath(entry)+rest), func(tn *trieNode, path []byte) {
+			t.expiries.set(tn, string(path), deadline)
+			matched++
+		})
+	} else if completeWalk && rest == "" && node.endOfKey {
+		t.expiries.set(node, string(*entry), deadline)
+		matched++
+	}
+	t.rw.Unlock()
+	if ttl <= 0 {
+		t.SweepExpired()
+	}
+	return matched
+}
+
+// calls "visit" with every node holding a key below "tn" and its key
+func dfsVisitNodes(tn *trieNode, path []byte, visit func(tn *trieNode, path []byte)) {
+	if tn.endOfKey {
+		visit(tn, path)
+	}
+	for _, child := range tn.sortedChildren() {
+		dfsVisitNodes(child, append(path, child.label...), visit)
+	}
+}
+
+// removes the keys whose time to live has passed
+// returns the number of removed keys
+// keys also expire lazily when the repository is accessed,
+// sweeping releases the memory of keys that are not accessed anymore
+func (t *Repository) SweepExpired() int {
+	t.rw.Lock()
+	events := t.removeExpired(t.clock.Now())
+	t.rw.Unlock()
+	for _, event := range events {
+		t.watchers.publish(event)
+	}
+	return len(events)
+}
+
+// must hold the write lock
+func (t *Repository) removeExpired(now time.Time) []ChangeEvent {
+	events := make([]ChangeEvent, 0)
+	for len(t.expiries.heap) > 0 && !t.expiries.heap[0].deadline.After(now) {
+		expired := heap.Pop(&t.expiries.heap).(*expiryEntry)
+		delete(t.expiries.entries, expired.node)
+		value, _ := t.currentCounts()(expired.node)
+		if t.removeKeyLocked(expired.key) {
+			events = append(events, ChangeEvent{Key: expired.key, Old: value, Op: OperationExpire})
+		}
+	}
+	t.expiries.updateNext()
+	return events
+}
+
+// removes the expired keys if any key is due, so reads never see them
+func (t *Repository) expireDue() {
+	next := atomic.LoadInt64(&t.expiries.next)
+	if next != 0 && t.clock.Now().UnixNano() >= next {
+		t.SweepExpired()
+	}
+}
+
+// removes the expired keys every "interval" until "ctx" is done
+func (t *Repository) StartSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.expireDue()
+			}
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package trie
+
+import (
+	"context"
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func buildClockTrie() (*Repository, *clock.Manual) {
+	manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	return buildDefaultTrie().AddClock(manual), manual
+}
+
+func TestRepository_InsertWithTTL_ExpiresOnAccess(t *testing.T) {
+	repo, manual := buildClockTrie()
+	assert.NoError(t, repo.InsertWithTTL("session/a", 1, time.Minute))
+	assert.NoError(t, repo.InsertWithTTL("session/b", 2, 2*time.Minute))
+	assert.NoError(t, repo.Insert("session/c", 3))
+	assert.Equal(t, 3, repo.Size())
+
+	manual.Advance(time.Minute)
+	assert.Equal(t, 2, repo.Size())
+	assert.False(t, repo.Contains("session/a"))
+	assert.Equal(t, 0, repo.GetValue("session/a"))
+	assert.Equal(t, map[string]int{"session/b": 2, "session/c": 3}, repo.GetMap("session/*"))
+	assert.Equal(t, 5, repo.GetValue("session/*"))
+
+	manual.Advance(time.Minute)
+	assert.Equal(t, map[string]int{"session/c": 3}, repo.GetMap("*"))
+	assert.Equal(t, 1, repo.Stats().Keys)
+}
+
+func TestRepository_InsertWithTTL_ReinsertAfterExpiry(t *testing.T) {
+	repo, manual := buildClockTrie()
+	assert.NoError(t, repo.InsertWithTTL("a", 1, time.Second))
+	assert.IsType(t, &key.ErrKeyAlreadyExists{}, repo.Insert("a", 2))
+	manual.Advance(time.Second)
+	assert.NoError(t, repo.Insert("a", 2))
+	manual.Advance(time.Hour)
+	assert.Equal(t, 2, repo.GetValue("a"))
+	_, ok := repo.TTL("a")
+	assert.False(t, ok)
+}
+
+func TestRepository_SetTTL(t *testing.T) {
+	repo, manual := buildClockTrie()
+	_ = repo.Insert("a", 1)
+	assert.NoError(t, repo.SetTTL("a", time.Minute))
+	manual.Advance(30 * time.Second)
+	ttl, ok := repo.TTL("a")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, ttl)
+	// a new time to live replaces the previous one
+	assert.NoError(t, repo.SetTTL("a", time.Minute))
+	manual.Advance(45 * time.Second)
+	assert.True(t, repo.Contains("a"))
+	// no time to live, no expiry
+	assert.NoError(t, repo.SetTTL("a", 0))
+	manual.Advance(time.Hour)
+	assert.True(t, repo.Contains("a"))
+
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.SetTTL("b", time.Minute))
+	assert.IsType(t, &key.ErrSelectorKeyNotAllowed{}, repo.SetTTL("a*", time.Minute))
+}
+
+func TestRepository_Expire(t *testing.T) {
+	manual := clock.NewManual(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	repo := buildTrieFromTokens(1, "home/a", "home/b", "home/b/c", "work/a").AddClock(manual)
+	_ = repo.Inc("home/*")
+	assert.Equal(t, 3, repo.Expire("home/*", time.Minute))
+	assert.Equal(t, 1, repo.Expire("work/a", 2*time.Minute))
+	assert.Equal(t, 0, repo.Expire("missing", time.Minute))
+
+	manual.Advance(time.Minute)
+	// pending selector increments are not keys and stay
+	assert.Equal(t, map[string]int{"work/a": 1, "home/*": 1}, repo.GetMap("*"))
+	assert.Equal(t, 1, repo.Size())
+
+	assert.Equal(t, 1, repo.Expire("work/*", 0))
+	assert.Equal(t, 0, repo.Size())
+}
+
+func TestRepository_Expire_NotifiesWatchers(t *testing.T) {
+	repo, manual := buildClockTrie()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := repo.Watch(ctx, "*")
+	_ = repo.InsertWithTTL("a", 7, time.Second)
+	manual.Advance(time.Second)
+	assert.Equal(t, 1, repo.SweepExpired())
+
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, OperationInsert, event.Op)
+	event, _ = nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "a", Old: 7, New: 0, Op: OperationExpire}, event)
+}
+
+func TestRepository_SweepExpired_KeepsShape(t *testing.T) {
+	repo, manual := buildClockTrie()
+	for _, k := range []string{"abc", "abd", "ab", "x"} {
+		_ = repo.InsertWithTTL(k, 1, time.Second)
+	}
+	_ = repo.Insert("abde", 1)
+	manual.Advance(time.Second)
+	assert.Equal(t, 4, repo.SweepExpired())
+	assert.Equal(t, 0, repo.SweepExpired())
+	stats := repo.Stats()
+	// only the root and "abde" are left
+	assert.Equal(t, 2, stats.Nodes)
+	assert.Equal(t, map[string]int{"abde": 1}, repo.GetMap("*"))
+	assert.NoError(t, repo.Insert("abc", 2))
+	assert.Equal(t, map[string]int{"abc": 2, "abde": 1}, repo.GetMap("ab*"))
+}
+
+func TestRepository_StartSweeper(t *testing.T) {
+	repo, manual := buildClockTrie()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := repo.Watch(ctx, "*")
+	_ = repo.InsertWithTTL("a", 1, time.Second)
+	<-events
+	repo.StartSweeper(ctx, time.Millisecond)
+	manual.Advance(time.Second)
+	// the sweeper removes the key without any access
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, OperationExpire, event.Op)
+}
+
+func TestRepository_Load_ClearsDeadlines(t *testing.T) {
+	repo, manual := buildClockTrie()
+	_ = repo.InsertWithTTL("a", 1, time.Second)
+	snapshot, err := buildTrieFromTokens(2, "a").MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, repo.UnmarshalBinary(snapshot))
+	manual.Advance(time.Hour)
+	assert.Equal(t, 2, repo.GetValue("a"))
+}
@@ -0,0 +1,271 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/key"
+)
+
+// immutable, succinct copy of a repository for read-only datasets
+// the trie is stored as a LOUDS (level-order unary degree sequence):
+// nodes are numbered in breadth first order, one node per byte of the keys,
+// and every node writes one bit set per child followed by a bit unset
+// the children of a node are consecutive numbers, so a node costs
+// two bits of shape and one byte of label, plus its values if it has any
+type Frozen struct {
+	louds bitVector
+	// byte of the edge leading to every node, the root has none
+	labels []byte
+	// nodes where a key ends and their values, in node order
+	terminals bitVector
+	values    []int64
+	// nodes with a pending selector increment and their increments
+	selectors      bitVector
+	selectorValues []int64
+	size           int
+	converter      key.Converter
+	validator      key.Validator
+	// unmaps the file of a Frozen trie opened with OpenFrozen
+	release func() error
+}
+
+var _ pkg.KeyValueRepository = (*Frozen)(nil)
+
+func (f *Frozen) AddConverter(converter key.Converter) *Frozen {
+	f.converter = converter
+	return f
+}
+
+func (f *Frozen) AddValidator(validator key.Validator) *Frozen {
+	f.validator = validator
+	return f
+}
+
+// compiles the current content of the repository into a Frozen trie
+// later changes to the repository are not visible to the Frozen trie
+func (t *Repository) Freeze() *Frozen {
+	t.expireDue()
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	var (
+		louds     bitVectorBuilder
+		terminals bitVectorBuilder
+		selectors bitVectorBuilder
+		frozen    = &Frozen{
+			labels:    []byte{0},
+			size:      t.size,
+			converter: t.converter,
+			validator: t.validator,
+		}
+	)
+	// diff points see the radix tree as one point per byte
+	queue := []*diffPoint{{node: t.root, counts: t.currentCounts()}}
+	for len(queue) > 0 {
+		point := queue[0]
+		queue = queue[1:]
+		value, isKey := point.key()
+		terminals.push(isKey)
+		if isKey {
+			frozen.values = append(frozen.values, int64(value))
+		}
+		selector, hasSelector := point.selector()
+		selectors.push(hasSelector)
+		if hasSelector {
+			frozen.selectorValues = append(frozen.selectorValues, int64(selector))
+		}
+		for _, child := range point.children() {
+			louds.push(true)
+			frozen.labels = append(frozen.labels, child.symbol)
+			queue = append(queue, child.point)
+		}
+		louds.push(false)
+	}
+	frozen.louds = louds.build()
+	frozen.terminals = terminals.build()
+	frozen.selectors = selectors.build()
+	return frozen
+}
+
+// returns the number of the first child of node "i" and the number of children
+func (f *Frozen) children(i int) (first, count int) {
+	start := 0
+	if i > 0 {
+		start = f.louds.select0(i-1) + 1
+	}
+	// every node before the block of "i" wrote exactly one bit unset,
+	// so the bits set before it are start - i, the root being node 0
+	return start - i + 1, f.louds.nextZero(start) - start
+}
+
+// returns the child of node "i" on the edge labeled "b"
+func (f *Frozen) child(i int, b byte) (int, bool) {
+	first, count := f.children(i)
+	low, high := first, first+count
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if f.labels[middle] < b {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	if low < first+count && f.labels[low] == b {
+		return low, true
+	}
+	return 0, false
+}
+
+// returns the node at the end of "path"
+func (f *Frozen) walk(path string) (int, bool) {
+	node := 0
+	for idx := 0; idx < len(path); idx++ {
+		child, ok := f.child(node, path[idx])
+		if !ok {
+			return 0, false
+		}
+		node = child
+	}
+	return node, true
+}
+
+func (f *Frozen) value(i int) (int, bool) {
+	if !f.terminals.get(i) {
+		return 0, false
+	}
+	return int(f.values[f.terminals.rank1(i)]), true
+}
+
+func (f *Frozen) selector(i int) (int, bool) {
+	if !f.selectors.get(i) {
+		return 0, false
+	}
+	return int(f.selectorValues[f.selectors.rank1(i)]), true
+}
+
+// visits the keys below node "i" in lexicographic order,
+// pending increments included, until "visit" returns false
+// returns false if the visit has been stopped
+func (f *Frozen) dfsVisitKeys(i int, path []byte, visit func(path []byte, value int) bool) bool {
+	if value, ok := f.value(i); ok && !visit(path, value) {
+		return false
+	}
+	selector, hasSelector := f.selector(i)
+	first, count := f.children(i)
+	for child := first; child < first+count; child++ {
+		if hasSelector && f.labels[child] >= key.SelectorChar {
+			if !visit(append(path, key.SelectorChar), selector) {
+				return false
+			}
+			hasSelector = false
+		}
+		if !f.dfsVisitKeys(child, append(path, f.labels[child]), visit) {
+			return false
+		}
+	}
+	if hasSelector {
+		return visit(append(path, key.SelectorChar), selector)
+	}
+	return true
+}
+
+func (f *Frozen) dfsGetValue(i int, carry int) int {
+	result := 0
+	selector, _ := f.selector(i)
+	carry += selector
+	if value, ok := f.value(i); ok {
+		result += value + carry
+	}
+	first, count := f.children(i)
+	for child := first; child < first+count; child++ {
+		result += f.dfsGetValue(child, carry)
+	}
+	return result
+}
+
+// calls "visit" for every key matching "pattern" in lexicographic order
+// until it returns false, a selector pattern also visits
+// the pending selector increments, e.g. "home/*"
+func (f *Frozen) Walk(pattern string, visit func(key string, value int) bool) {
+	entry := key.New(pattern, f.converter, f.validator)
+	node, ok := f.walk(entryPath(entry))
+	if !ok {
+		return
+	}
+	if entry.IsRaw() {
+		if value, isKey := f.value(node); isKey {
+			visit(string(*entry), value)
+		}
+		return
+	}
+	f.dfsVisitKeys(node, []byte(entryPath(entry)), func(path []byte, value int) bool {
+		return visit(string(path), value)
+	})
+}
+
+func (f *Frozen) GetMap(pattern string) map[string]int {
+	results := make(map[string]int)
+	f.Walk(pattern, func(key string, value int) bool {
+		results[key] = value
+		return true
+	})
+	return results
+}
+
+func (f *Frozen) GetValue(pattern string) int {
+	entry := key.New(pattern, f.converter, f.validator)
+	node, ok := f.walk(entryPath(entry))
+	if !ok {
+		return 0
+	}
+	if entry.IsSelector() {
+		return f.dfsGetValue(node, 0)
+	}
+	value, _ := f.value(node)
+	selector, _ := f.selector(node)
+	return value + selector
+}
+
+func (f *Frozen) Contains(pattern string) bool {
+	entry := key.New(pattern, f.converter, f.validator)
+	node, ok := f.walk(entryPath(entry))
+	if !ok {
+		return false
+	}
+	if f.terminals.get(node) {
+		return true
+	}
+	_, count := f.children(node)
+	return entry.IsSelector() && (count > 0 || f.selectors.get(node))
+}
+
+// returns the longest key that is a prefix of "text", and its value
+// as returned by GetValue, "text" is converted and validated like a key
+func (f *Frozen) LongestPrefixOf(text string) (prefix string, value int, ok bool) {
+	entry := string(*key.New(text, f.converter, f.validator))
+	node := 0
+	for idx := 0; idx < len(entry); idx++ {
+		child, found := f.child(node, entry[idx])
+		if !found {
+			break
+		}
+		node = child
+		if keyValue, isKey := f.value(node); isKey {
+			selector, _ := f.selector(node)
+			prefix, value, ok = entry[:idx+1], keyValue+selector, true
+		}
+	}
+	return prefix, value, ok
+}
+
+func (f *Frozen) Size() int {
+	return f.size
+}
+
+// always fails, a Frozen trie cannot be modified
+func (f *Frozen) Insert(pattern string, value int) error {
+	return NewErrReadOnly(pattern)
+}
+
+// always fails, a Frozen trie cannot be modified
+func (f *Frozen) Inc(pattern string) error {
+	return NewErrReadOnly(pattern)
+}
@@ -0,0 +1,320 @@
+package trie
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/intenvy/memoir/pkg/key"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+// file layout of a Frozen trie, all integers are little endian:
+//
+//	magic "MEMF" | version (uint32) | number of keys (uint64)
+//	louds bit vector | labels | terminals bit vector | values
+//	selectors bit vector | selector values | crc32 of everything before it (uint32)
+//
+// a bit vector is its number of bits followed by its words, rank samples
+// and select samples, every array is its number of elements (uint64)
+// followed by the elements, padded to a multiple of 8 bytes
+// the arrays are used in place, so a mapped file is never copied to the heap
+// the checksum is checked on open, so queries never walk corrupt arrays
+const (
+	frozenMagic   = "MEMF"
+	frozenVersion = 2
+)
+
+// whether the host stores integers little endian,
+// only then can the arrays of a file be used in place
+var nativeLittleEndian = func() bool {
+	probe := uint16(1)
+	return *(*byte)(unsafe.Pointer(&probe)) == 1
+}()
+
+// writes the Frozen trie to "w" in a format that OpenFrozen can map
+func (f *Frozen) Save(w io.Writer) error {
+	checksum := crc32.NewIEEE()
+	fw := &frozenWriter{w: bufio.NewWriter(io.MultiWriter(w, checksum))}
+	fw.write([]byte(frozenMagic))
+	fw.write(fw.uint32Bytes(frozenVersion))
+	fw.write(fw.uint64Bytes(uint64(f.size)))
+	fw.writeBitVector(&f.louds)
+	fw.writeArray(len(f.labels), 1, func(idx int) []byte { return f.labels[idx : idx+1] })
+	fw.writeBitVector(&f.terminals)
+	fw.writeArray(len(f.values), 8, func(idx int) []byte { return fw.uint64Bytes(uint64(f.values[idx])) })
+	fw.writeBitVector(&f.selectors)
+	fw.writeArray(len(f.selectorValues), 8, func(idx int) []byte { return fw.uint64Bytes(uint64(f.selectorValues[idx])) })
+	if fw.err != nil {
+		return fw.err
+	}
+	if err := fw.w.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(fw.uint32Bytes(checksum.Sum32()))
+	return err
+}
+
+type frozenWriter struct {
+	w       *bufio.Writer
+	scratch [8]byte
+	err     error
+}
+
+func (fw *frozenWriter) write(p []byte) {
+	if fw.err != nil {
+		return
+	}
+	_, fw.err = fw.w.Write(p)
+}
+
+func (fw *frozenWriter) uint32Bytes(v uint32) []byte {
+	binary.LittleEndian.PutUint32(fw.scratch[:4], v)
+	return fw.scratch[:4]
+}
+
+func (fw *frozenWriter) uint64Bytes(v uint64) []byte {
+	binary.LittleEndian.PutUint64(fw.scratch[:], v)
+	return fw.scratch[:]
+}
+
+// writes "count" elements of "width" bytes, padded to a multiple of 8 bytes
+func (fw *frozenWriter) writeArray(count, width int, element func(idx int) []byte) {
+	fw.write(fw.uint64Bytes(uint64(count)))
+	for idx := 0; idx < count; idx++ {
+		fw.write(element(idx))
+	}
+	var padding [8]byte
+	fw.write(padding[:(8-count*width%8)%8])
+}
+
+func (fw *frozenWriter) writeBitVector(bv *bitVector) {
+	fw.write(fw.uint64Bytes(uint64(bv.size)))
+	fw.writeArray(len(bv.words), 8, func(idx int) []byte { return fw.uint64Bytes(bv.words[idx]) })
+	fw.writeArray(len(bv.ranks), 4, func(idx int) []byte { return fw.uint32Bytes(bv.ranks[idx]) })
+	fw.writeArray(len(bv.zeroBlocks), 4, func(idx int) []byte { return fw.uint32Bytes(bv.zeroBlocks[idx]) })
+}
+
+// reads the arrays of a Frozen trie out of a file held in memory
+type frozenReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (fr *frozenReader) fail(reason string) {
+	if fr.err == nil {
+		fr.err = NewErrCorruptSnapshot(reason)
+	}
+}
+
+func (fr *frozenReader) next(size int) []byte {
+	if fr.err != nil {
+		return nil
+	}
+	if size < 0 || size > len(fr.data)-fr.offset {
+		fr.fail("truncated frozen trie")
+		return nil
+	}
+	chunk := fr.data[fr.offset : fr.offset+size]
+	fr.offset += size
+	return chunk
+}
+
+func (fr *frozenReader) readUint64() uint64 {
+	chunk := fr.next(8)
+	if chunk == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(chunk)
+}
+
+// returns the bytes of the next array of elements of "width" bytes
+func (fr *frozenReader) readArray(width int) (chunk []byte, count int) {
+	length := fr.readUint64()
+	if length > uint64(len(fr.data)) {
+		fr.fail("invalid array length")
+		return nil, 0
+	}
+	count = int(length)
+	chunk = fr.next(count * width)
+	fr.next((8 - count*width%8) % 8)
+	return chunk, count
+}
+
+func (fr *frozenReader) readBytes() []byte {
+	chunk, _ := fr.readArray(1)
+	return chunk
+}
+
+func (fr *frozenReader) readUint32s() []uint32 {
+	chunk, count := fr.readArray(4)
+	if fr.err != nil || count == 0 {
+		return nil
+	}
+	if nativeLittleEndian {
+		var out []uint32
+		header := (*reflect.SliceHeader)(unsafe.Pointer(&out))
+		header.Data = uintptr(unsafe.Pointer(&chunk[0]))
+		header.Len, header.Cap = count, count
+		return out
+	}
+	out := make([]uint32, count)
+	for idx := range out {
+		out[idx] = binary.LittleEndian.Uint32(chunk[idx*4:])
+	}
+	return out
+}
+
+func (fr *frozenReader) readUint64s() []uint64 {
+	chunk, count := fr.readArray(8)
+	if fr.err != nil || count == 0 {
+		return nil
+	}
+	if nativeLittleEndian {
+		var out []uint64
+		header := (*reflect.SliceHeader)(unsafe.Pointer(&out))
+		header.Data = uintptr(unsafe.Pointer(&chunk[0]))
+		header.Len, header.Cap = count, count
+		return out
+	}
+	out := make([]uint64, count)
+	for idx := range out {
+		out[idx] = binary.LittleEndian.Uint64(chunk[idx*8:])
+	}
+	return out
+}
+
+func (fr *frozenReader) readInt64s() []int64 {
+	words := fr.readUint64s()
+	if len(words) == 0 {
+		return nil
+	}
+	var out []int64
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&out))
+	header.Data = uintptr(unsafe.Pointer(&words[0]))
+	header.Len, header.Cap = len(words), len(words)
+	return out
+}
+
+// reads a bit vector and checks that its samples match its size
+// returns the bit vector and its number of ones
+func (fr *frozenReader) readBitVector() (bitVector, int) {
+	size := fr.readUint64()
+	bv := bitVector{
+		words:      fr.readUint64s(),
+		ranks:      fr.readUint32s(),
+		zeroBlocks: fr.readUint32s(),
+	}
+	if fr.err != nil {
+		return bitVector{}, 0
+	}
+	words := (size + 63) / 64
+	if size > uint64(len(fr.data))*8 || uint64(len(bv.words)) != words ||
+		len(bv.ranks) != (len(bv.words)+wordsPerRankBlock-1)/wordsPerRankBlock {
+		fr.fail("invalid bit vector")
+		return bitVector{}, 0
+	}
+	bv.size = int(size)
+	ones := 0
+	if len(bv.ranks) > 0 {
+		last := len(bv.ranks) - 1
+		ones = int(bv.ranks[last]) + bv.onesIn(last)
+	}
+	zeros := len(bv.words)*64 - ones
+	if len(bv.zeroBlocks) != (zeros+zerosPerSelectSample-1)/zerosPerSelectSample {
+		fr.fail("invalid bit vector")
+		return bitVector{}, 0
+	}
+	for _, block := range bv.zeroBlocks {
+		if int(block) >= len(bv.ranks) {
+			fr.fail("invalid bit vector")
+			return bitVector{}, 0
+		}
+	}
+	// bits past the size must be unset for the ranks to be right
+	if tail := uint(size % 64); tail != 0 && bv.words[len(bv.words)-1]>>tail != 0 {
+		fr.fail("invalid bit vector")
+		return bitVector{}, 0
+	}
+	return bv, ones
+}
+
+// decodes a Frozen trie from "data" without copying its arrays,
+// "data" must stay valid and unchanged while the Frozen trie is in use
+func readFrozen(data []byte) (*Frozen, error) {
+	fr := &frozenReader{data: data}
+	if string(fr.next(len(frozenMagic))) != frozenMagic {
+		return nil, NewErrCorruptSnapshot("missing magic header")
+	}
+	version := fr.next(4)
+	if fr.err != nil || binary.LittleEndian.Uint32(version) != frozenVersion {
+		return nil, NewErrCorruptSnapshot("unsupported version")
+	}
+	if len(data) < fr.offset+4 {
+		return nil, NewErrCorruptSnapshot("truncated frozen trie")
+	}
+	body := len(data) - 4
+	if crc32.ChecksumIEEE(data[:body]) != binary.LittleEndian.Uint32(data[body:]) {
+		return nil, NewErrCorruptSnapshot("checksum mismatch")
+	}
+	fr.data = data[:body]
+	f := &Frozen{
+		converter: key.NewConverterPipeline(),
+		validator: key.NewValidatorPipeline(),
+	}
+	f.size = int(fr.readUint64())
+	var loudsOnes, terminalOnes, selectorOnes int
+	f.louds, loudsOnes = fr.readBitVector()
+	f.labels = fr.readBytes()
+	f.terminals, terminalOnes = fr.readBitVector()
+	f.values = fr.readInt64s()
+	f.selectors, selectorOnes = fr.readBitVector()
+	f.selectorValues = fr.readInt64s()
+	if fr.err != nil {
+		return nil, fr.err
+	}
+	nodes := len(f.labels)
+	// every node but the root is a child, and every node ends its children
+	if nodes == 0 || loudsOnes != nodes-1 || f.louds.size != 2*nodes-1 ||
+		f.terminals.size != nodes || f.selectors.size != nodes ||
+		len(f.values) != terminalOnes || len(f.selectorValues) != selectorOnes ||
+		f.size != terminalOnes || f.louds.get(f.louds.size-1) || fr.offset != len(fr.data) {
+		return nil, NewErrCorruptSnapshot("inconsistent frozen trie")
+	}
+	return f, nil
+}
+
+// opens a Frozen trie written by Save, the file is mapped in memory
+// where the platform supports it, so opening does not depend on its size
+// and processes opening the same file share its pages
+// the checksum of the file is checked, so opening reads it once
+// keys are not converted nor validated until a converter
+// and a validator are added, and the Frozen trie must be closed
+// to release the file
+func OpenFrozen(path string) (*Frozen, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := readFrozen(data)
+	if err != nil {
+		_ = release()
+		return nil, err
+	}
+	f.release = release
+	return f, nil
+}
+
+// releases the file of a Frozen trie opened with OpenFrozen
+// the Frozen trie must not be used afterwards
+// it does nothing on a Frozen trie built with Freeze
+func (f *Frozen) Close() error {
+	if f.release == nil {
+		return nil
+	}
+	release := f.release
+	*f = Frozen{converter: f.converter, validator: f.validator}
+	return release()
+}
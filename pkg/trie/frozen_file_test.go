@@ -0,0 +1,96 @@
+package trie
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func saveFrozenFile(t *testing.T, frozen *Frozen) string {
+	dir, err := ioutil.TempDir("", "frozen")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "frozen.memf")
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, frozen.Save(buffer))
+	assert.NoError(t, ioutil.WriteFile(path, buffer.Bytes(), 0644))
+	return path
+}
+
+func TestOpenFrozen_RoundTrip(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	frozen := repo.Freeze()
+	opened, err := OpenFrozen(saveFrozenFile(t, frozen))
+	assert.NoError(t, err)
+	defer opened.Close()
+	opened.AddConverter(repo.converter).AddValidator(repo.validator)
+	assert.Equal(t, frozen.Size(), opened.Size())
+	for _, pattern := range []string{"*", "home/*", "home/bin/*", "HOME/BIN/TAR", "root/*", "root/etc", "ünï/*", "x*"} {
+		assert.Equal(t, frozen.GetMap(pattern), opened.GetMap(pattern), pattern)
+		assert.Equal(t, frozen.GetValue(pattern), opened.GetValue(pattern), pattern)
+		assert.Equal(t, frozen.Contains(pattern), opened.Contains(pattern), pattern)
+	}
+	prefix, _, ok := opened.LongestPrefixOf("home/bin/tar.gz")
+	assert.True(t, ok)
+	assert.Equal(t, "home/bin/tar", prefix)
+}
+
+func TestOpenFrozen_Large(t *testing.T) {
+	// spans many rank blocks and select samples
+	repo := New()
+	for _, k := range buildPathDataset(5000) {
+		_ = repo.Insert(k, len(k))
+	}
+	_ = repo.Inc("org/org-1/*")
+	frozen := repo.Freeze()
+	opened, err := OpenFrozen(saveFrozenFile(t, frozen))
+	assert.NoError(t, err)
+	defer opened.Close()
+	assert.Equal(t, repo.GetMap("*"), opened.GetMap("*"))
+	assert.Equal(t, repo.GetValue("org/org-1/team/*"), opened.GetValue("org/org-1/team/*"))
+}
+
+func TestOpenFrozen_Empty(t *testing.T) {
+	opened, err := OpenFrozen(saveFrozenFile(t, New().Freeze()))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, opened.Size())
+	assert.Empty(t, opened.GetMap("*"))
+	assert.NoError(t, opened.Close())
+}
+
+func TestOpenFrozen_Corrupt(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, buildSampleSnapshotTrie().Freeze().Save(buffer))
+	valid := buffer.Bytes()
+
+	_, err := readFrozen(nil)
+	assert.IsType(t, &ErrCorruptSnapshot{}, err)
+	_, err = readFrozen([]byte("MEMR\x01\x00\x00\x00"))
+	assert.IsType(t, &ErrCorruptSnapshot{}, err)
+	for size := 0; size < len(valid); size++ {
+		_, err = readFrozen(valid[:size])
+		assert.Error(t, err, "truncated to %d bytes", size)
+	}
+	// first word of the louds bit vector, after the header and two lengths
+	corrupt := append([]byte(nil), valid...)
+	corrupt[32] ^= 1
+	_, err = readFrozen(corrupt)
+	assert.IsType(t, &ErrCorruptSnapshot{}, err)
+	// labels and values are only covered by the checksum
+	for idx := range valid {
+		corrupt := append([]byte(nil), valid...)
+		corrupt[idx] ^= 0x10
+		_, err = readFrozen(corrupt)
+		assert.Error(t, err, "byte %d flipped", idx)
+	}
+	_, err = readFrozen(append(append([]byte(nil), valid...), 0))
+	assert.IsType(t, &ErrCorruptSnapshot{}, err)
+}
+
+func TestOpenFrozen_MissingFile(t *testing.T) {
+	_, err := OpenFrozen(filepath.Join(os.TempDir(), "missing", "frozen.memf"))
+	assert.True(t, os.IsNotExist(err))
+}
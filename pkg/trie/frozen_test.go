@@ -0,0 +1,122 @@
+package trie
+
+import (
+	"fmt"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestRepository_Freeze_ReadMethods(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	frozen := repo.Freeze()
+	assert.Equal(t, repo.Size(), frozen.Size())
+	patterns := []string{
+		"*", "home/*", "home/", "home/bin/*", "home/bin/", "home/bin/tar",
+		"hom*", "root/*", "root/etc", "root/", "ünï/*", "ünï/cødé", "missing", "x*",
+	}
+	for _, pattern := range patterns {
+		assert.Equal(t, repo.GetMap(pattern), frozen.GetMap(pattern), pattern)
+		assert.Equal(t, repo.GetValue(pattern), frozen.GetValue(pattern), pattern)
+		assert.Equal(t, repo.Contains(pattern), frozen.Contains(pattern), pattern)
+	}
+}
+
+func TestRepository_Freeze_Empty(t *testing.T) {
+	frozen := buildDefaultTrie().Freeze()
+	assert.Equal(t, 0, frozen.Size())
+	assert.Empty(t, frozen.GetMap("*"))
+	assert.Equal(t, 0, frozen.GetValue("*"))
+	assert.False(t, frozen.Contains("*"))
+	_, _, ok := frozen.LongestPrefixOf("abc")
+	assert.False(t, ok)
+}
+
+func TestRepository_Freeze_IsolatedFromRepository(t *testing.T) {
+	repo := buildTrieFromTokens(1, "abc")
+	frozen := repo.Freeze()
+	_ = repo.Insert("abd", 1)
+	_ = repo.Inc("abc")
+	assert.Equal(t, map[string]int{"abc": 1}, frozen.GetMap("*"))
+}
+
+func TestFrozen_Walk_Ordered(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	frozen := repo.Freeze()
+	expected := make([]string, 0)
+	repo.visitMatches(key.New("*", repo.converter, repo.validator), func(path []byte, value int) {
+		expected = append(expected, fmt.Sprintf("%s=%d", path, value))
+	})
+	actual := make([]string, 0)
+	frozen.Walk("*", func(k string, value int) bool {
+		actual = append(actual, fmt.Sprintf("%s=%d", k, value))
+		return true
+	})
+	assert.Equal(t, expected, actual)
+}
+
+func TestFrozen_Walk_Stops(t *testing.T) {
+	frozen := buildTrieFromTokens(1, "a", "b", "c").Freeze()
+	visited := make([]string, 0)
+	frozen.Walk("*", func(k string, value int) bool {
+		visited = append(visited, k)
+		return len(visited) < 2
+	})
+	assert.Equal(t, []string{"a", "b"}, visited)
+}
+
+func TestFrozen_LongestPrefixOf(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	frozen := repo.Freeze()
+	for _, text := range []string{"home/bin/tar.gz", "home/bin/", "home/x", "root/etc/passwd", "ROOT/ETC", "usr", "h"} {
+		prefix, value, ok := repo.LongestPrefixOf(text)
+		frozenPrefix, frozenValue, frozenOk := frozen.LongestPrefixOf(text)
+		assert.Equal(t, prefix, frozenPrefix, text)
+		assert.Equal(t, value, frozenValue, text)
+		assert.Equal(t, ok, frozenOk, text)
+	}
+	prefix, value, ok := frozen.LongestPrefixOf("home/bin/tar.gz")
+	assert.True(t, ok)
+	assert.Equal(t, "home/bin/tar", prefix)
+	assert.Equal(t, 1, value)
+}
+
+func TestFrozen_Modify_ReadOnly(t *testing.T) {
+	frozen := buildTrieFromTokens(1, "abc").Freeze()
+	assert.IsType(t, &ErrReadOnly{}, frozen.Insert("abd", 1))
+	assert.IsType(t, &ErrReadOnly{}, frozen.Inc("abc"))
+	assert.Equal(t, 1, frozen.GetValue("abc"))
+}
+
+func TestRepository_Freeze_Random(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	const alphabet = "ab/*é"
+	randomText := func(maxSize int) string {
+		text := make([]byte, 0, maxSize)
+		for size := rnd.Intn(maxSize); len(text) <= size; {
+			text = append(text, alphabet[rnd.Intn(len(alphabet))])
+		}
+		return string(text)
+	}
+	repo := New()
+	for i := 0; i < 2000; i++ {
+		text := randomText(8)
+		if rnd.Intn(4) == 0 {
+			_ = repo.Inc(text[:rnd.Intn(len(text))] + "*")
+		} else if text[len(text)-1] != '*' {
+			_ = repo.Insert(text, rnd.Intn(100))
+		}
+	}
+	frozen := repo.Freeze()
+	assert.Equal(t, repo.GetMap("*"), frozen.GetMap("*"))
+	for i := 0; i < 2000; i++ {
+		pattern := randomText(6)
+		assert.Equal(t, repo.GetMap(pattern), frozen.GetMap(pattern), pattern)
+		assert.Equal(t, repo.GetValue(pattern), frozen.GetValue(pattern), pattern)
+		assert.Equal(t, repo.Contains(pattern), frozen.Contains(pattern), pattern)
+		prefix, value, ok := repo.LongestPrefixOf(pattern)
+		frozenPrefix, frozenValue, frozenOk := frozen.LongestPrefixOf(pattern)
+		assert.Equal(t, []interface{}{prefix, value, ok}, []interface{}{frozenPrefix, frozenValue, frozenOk}, pattern)
+	}
+}
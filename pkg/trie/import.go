@@ -0,0 +1,171 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"github.com/intenvy/memoir/pkg/key"
+)
+
+// error of a single entry that could not be imported
+// Line is the 1-based line the entry was read from, or zero when
+// the source has no notion of lines or the line is not known
+// Record is the 1-based record of a CSV source the entry was read from,
+// as a record may span several lines, or zero for other sources
+type ImportError struct {
+	Line   int
+	Record int
+	Key    string
+	Err    error
+}
+
+var _ error = (*ImportError)(nil)
+
+func NewImportError(line int, pattern string, err error) *ImportError {
+	return &ImportError{Line: line, Key: pattern, Err: err}
+}
+
+func (e *ImportError) Error() string {
+	switch {
+	case e.Line > 0:
+		return fmt.Sprintf(`line %d: key: "%s": %s`, e.Line, e.Key, e.Err)
+	case e.Record > 0:
+		return fmt.Sprintf(`record %d: key: "%s": %s`, e.Record, e.Key, e.Err)
+	}
+	return fmt.Sprintf(`key: "%s": %s`, e.Key, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// what an import does with a key that already exists
+type DuplicatePolicy int
+
+const (
+	// reports the entry as an import error and keeps the existing value
+	DuplicateError DuplicatePolicy = iota
+	// silently keeps the existing value
+	DuplicateSkip
+	// replaces the existing value
+	DuplicateOverwrite
+	// adds the imported value to the existing value
+	DuplicateSum
+)
+
+// name of the operation reported to watchers for the keys
+// changed by ImportCSV, ImportJSON and ImportJSONL
+const OperationImport = "Import"
+
+// returned by importEntry when a duplicate entry is skipped
+var errDuplicateSkipped = errors.New("duplicate key skipped")
+
+// outcome of an import, entries that failed
+// are reported in Errors and do not stop the import
+type ImportResult struct {
+	Imported int
+	Skipped  int
+	Errors   []*ImportError
+}
+
+func (r *ImportResult) record(line int, pattern string, err error) {
+	r.recordAt(ImportError{Line: line, Key: pattern}, err)
+}
+
+// same as record for an entry read from the record "row" of a CSV source
+func (r *ImportResult) recordRow(row int, pattern string, err error) {
+	r.recordAt(ImportError{Record: row, Key: pattern}, err)
+}
+
+// counts the outcome "err" of the entry read at "at"
+func (r *ImportResult) recordAt(at ImportError, err error) {
+	switch {
+	case err == errDuplicateSkipped:
+		r.Skipped++
+	case err != nil:
+		at.Err = err
+		r.Errors = append(r.Errors, &at)
+	default:
+		r.Imported++
+	}
+}
+
+// imports a single exported entry through the converter and the validator
+// a raw key is inserted, a key ending with the selector
+// restores the pending selector increment of that prefix
+// keys that already exist are handled according to "policy"
+func (t *Repository) importEntry(pattern string, value int, policy DuplicatePolicy) error {
+	if err := t.validator.Validate(pattern); err != nil {
+		return err
+	}
+	entry := key.New(pattern, t.converter, t.validator)
+	if entry.Size() == 0 {
+		return key.NewErrEmptyKey(pattern)
+	}
+	t.expireDue()
+	event, err := t.importEntryLocked(entry, value, policy)
+	if err == nil {
+		t.watchers.publish(event)
+	}
+	t.reportEvictions()
+	return err
+}
+
+// returns the change of the entry, for the watchers
+func (t *Repository) importEntryLocked(entry *key.Key, value int, policy DuplicatePolicy) (ChangeEvent, error) {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	event := ChangeEvent{Key: string(*entry), New: value, Op: OperationImport}
+	node, keyExists, created := t.forceWalk(entry)
+	// a selector entry is stored in the pending increment of its prefix
+	stored := &node.value
+	oldValue, oldSelector := t.currentCounts()(node)
+	if entry.IsSelector() {
+		stored, keyExists = &node.selector, node.hasSelector
+		oldValue = oldSelector
+	}
+	if keyExists {
+		event.Old = oldValue
+		switch policy {
+		case DuplicateSkip:
+			return event, errDuplicateSkipped
+		case DuplicateOverwrite:
+			*stored = value
+		case DuplicateSum:
+			if t.halfLife > 0 {
+				// sums with the value decayed up to now
+				t.decayedInc(node, entry.IsSelector(), 0)
+			}
+			*stored += value
+		default:
+			return event, key.NewErrKeyAlreadyExist(*entry)
+		}
+		event.New = *stored
+		if t.halfLife > 0 {
+			t.setDecayed(node, entry.IsSelector())
+		}
+		if t.capacity != nil {
+			t.capacity.touch(node)
+		}
+		return event, nil
+	}
+	if t.rejectsGrowth(created, entry.IsRaw()) {
+		t.rollbackWalk(entryPath(entry))
+		return event, NewErrCapacityExceeded(string(*entry))
+	}
+	*stored = value
+	if t.halfLife > 0 {
+		t.setDecayed(node, entry.IsSelector())
+	}
+	if entry.IsSelector() {
+		node.hasSelector = true
+		t.enforceCapacity(nil)
+		return event, nil
+	}
+	node.endOfKey = true
+	t.size++
+	if t.capacity != nil {
+		t.capacity.add(node, string(*entry))
+		t.enforceCapacity(node)
+	}
+	return event, nil
+}
@@ -0,0 +1,257 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/intenvy/memoir/pkg/key"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// shape of the JSON documents read and written by the repository
+type JSONFormat int
+
+const (
+	// a single object mapping every key to its value
+	//	{"home/": 1, "home/bin/": 2, "home/bin/*": 1}
+	JSONFlat JSONFormat = iota
+	// objects nested along the trie, single child chains are collapsed
+	// into one member and the value of a key that has children
+	// is stored in its "" member
+	//	{"home/": {"": 1, "bin/": {"": 2, "*": 1}}}
+	JSONNested
+)
+
+// member of a nested object that holds the value of the key itself
+const nestedValueMember = ""
+
+// one line of the JSONL format
+type jsonLine struct {
+	Key   *string `json:"key"`
+	Value *int    `json:"value"`
+}
+
+type keyValue struct {
+	key   string
+	value int
+}
+
+// writes the keys that match "pattern" to "w" as a JSON document
+// pending selector increments are written as keys ending with the selector
+func (t *Repository) ExportJSON(w io.Writer, pattern string, format JSONFormat) error {
+	entries := t.matchingEntries(pattern)
+	out := bufio.NewWriter(w)
+	if format == JSONNested {
+		writeNestedJSON(out, entries, 0)
+	} else {
+		writeFlatJSON(out, entries)
+	}
+	_ = out.WriteByte('\n')
+	return out.Flush()
+}
+
+// reads a JSON document written by ExportJSON and imports its entries
+// through the converter and the validator, raw keys are inserted
+// and selector keys restore pending selector increments
+// a malformed document is returned as an error,
+// entries that cannot be imported are reported in the result
+func (t *Repository) ImportJSON(r io.Reader, format JSONFormat) (*ImportResult, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	object, isObject := document.(map[string]interface{})
+	if !isObject {
+		return nil, fmt.Errorf("json document must be an object")
+	}
+	entries := make([]keyValue, 0)
+	result := &ImportResult{}
+	if format == JSONNested {
+		flattenNestedJSON("", object, &entries, result)
+	} else {
+		for pattern, raw := range object {
+			value, err := jsonInt(raw)
+			if err != nil {
+				result.record(0, pattern, err)
+				continue
+			}
+			entries = append(entries, keyValue{key: pattern, value: value})
+		}
+	}
+	sortKeyValues(entries)
+	for _, entry := range entries {
+		result.record(0, entry.key, t.importEntry(entry.key, entry.value, DuplicateError))
+	}
+	return result, nil
+}
+
+// writes the keys that match "pattern" to "w", one JSON object per line
+//
+//	{"key":"home/","value":1}
+func (t *Repository) ExportJSONL(w io.Writer, pattern string) error {
+	entry := key.New(pattern, t.converter, t.validator)
+	out := bufio.NewWriter(w)
+	t.expireDue()
+	t.rw.RLock()
+	t.visitMatches(entry, func(path []byte, value int) {
+		writeJSONString(out, "{\"key\":", string(path))
+		_, _ = out.WriteString(",\"value\":")
+		_, _ = out.WriteString(strconv.Itoa(value))
+		_, _ = out.WriteString("}\n")
+	})
+	t.rw.RUnlock()
+	return out.Flush()
+}
+
+// reads "r" line by line and imports every JSONL entry
+// through the converter and the validator, empty lines are skipped
+// lines that are malformed or cannot be imported are reported
+// in the result and the error is only returned when "r" fails
+func (t *Repository) ImportJSONL(r io.Reader) (*ImportResult, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSnapshotKeySize)
+	result := &ImportResult{}
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var parsed jsonLine
+		if err := json.Unmarshal(text, &parsed); err != nil {
+			result.record(line, "", err)
+			continue
+		}
+		if parsed.Key == nil || parsed.Value == nil {
+			result.record(line, "", fmt.Errorf(`line must have a "key" and a "value"`))
+			continue
+		}
+		result.record(line, *parsed.Key, t.importEntry(*parsed.Key, *parsed.Value, DuplicateError))
+	}
+	return result, scanner.Err()
+}
+
+// returns the keys that match "pattern" with their values, ordered by key
+func (t *Repository) matchingEntries(pattern string) []keyValue {
+	entry := key.New(pattern, t.converter, t.validator)
+	entries := make([]keyValue, 0)
+	t.expireDue()
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	t.visitMatches(entry, func(path []byte, value int) {
+		entries = append(entries, keyValue{key: string(path), value: value})
+	})
+	return entries
+}
+
+func sortKeyValues(entries []keyValue) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+}
+
+func writeJSONString(out *bufio.Writer, prefix string, str string) {
+	encoded, _ := json.Marshal(str)
+	_, _ = out.WriteString(prefix)
+	_, _ = out.Write(encoded)
+}
+
+func writeFlatJSON(out *bufio.Writer, entries []keyValue) {
+	_ = out.WriteByte('{')
+	for idx, entry := range entries {
+		separator := ","
+		if idx == 0 {
+			separator = ""
+		}
+		writeJSONString(out, separator, entry.key)
+		_ = out.WriteByte(':')
+		_, _ = out.WriteString(strconv.Itoa(entry.value))
+	}
+	_ = out.WriteByte('}')
+}
+
+// writes sorted "entries" sharing their first "depth" bytes as a nested object
+// the entries are grouped by the rune that follows the shared bytes
+// and every group is collapsed into the longest prefix common to it
+func writeNestedJSON(out *bufio.Writer, entries []keyValue, depth int) {
+	_ = out.WriteByte('{')
+	first := true
+	separator := func() string {
+		if first {
+			first = false
+			return ""
+		}
+		return ","
+	}
+	if len(entries) > 0 && len(entries[0].key) == depth {
+		writeJSONString(out, separator(), nestedValueMember)
+		_ = out.WriteByte(':')
+		_, _ = out.WriteString(strconv.Itoa(entries[0].value))
+		entries = entries[1:]
+	}
+	for len(entries) > 0 {
+		_, size := utf8.DecodeRuneInString(entries[0].key[depth:])
+		groupPrefix := entries[0].key[:depth+size]
+		end := 1
+		for end < len(entries) && strings.HasPrefix(entries[end].key, groupPrefix) {
+			end++
+		}
+		group := entries[:end]
+		entries = entries[end:]
+
+		common := commonRunePrefix(group[0].key, group[len(group)-1].key)
+		writeJSONString(out, separator(), common[depth:])
+		_ = out.WriteByte(':')
+		if len(group) == 1 && len(group[0].key) == len(common) {
+			_, _ = out.WriteString(strconv.Itoa(group[0].value))
+			continue
+		}
+		writeNestedJSON(out, group, len(common))
+	}
+	_ = out.WriteByte('}')
+}
+
+// returns the longest common prefix of "a" and "b" that ends on a rune boundary
+func commonRunePrefix(a, b string) string {
+	size := sharedPrefixLength(a, b)
+	for size > 0 && size < len(a) && !utf8.RuneStart(a[size]) {
+		size--
+	}
+	return a[:size]
+}
+
+// collects the entries of a nested object, the keys of the members
+// along a path are concatenated to build the keys of the entries
+func flattenNestedJSON(prefix string, object map[string]interface{}, out *[]keyValue, result *ImportResult) {
+	for member, raw := range object {
+		path := prefix + member
+		if nested, isObject := raw.(map[string]interface{}); isObject {
+			flattenNestedJSON(path, nested, out, result)
+			continue
+		}
+		value, err := jsonInt(raw)
+		if err != nil {
+			result.record(0, path, err)
+			continue
+		}
+		*out = append(*out, keyValue{key: path, value: value})
+	}
+}
+
+func jsonInt(raw interface{}) (int, error) {
+	number, isNumber := raw.(json.Number)
+	if !isNumber {
+		return 0, fmt.Errorf("value must be an integer, got: %v", raw)
+	}
+	value, err := strconv.Atoi(number.String())
+	if err != nil {
+		return 0, fmt.Errorf("value must be an integer, got: %s", number)
+	}
+	return value, nil
+}
@@ -0,0 +1,89 @@
+package trie
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRepository_ExportJSON_Flat(t *testing.T) {
+	repo := buildTrieFromTokens(1, "home/", "home/bin/", "root/etc")
+	_ = repo.Inc("home/bin/*")
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportJSON(buffer, "home/*", JSONFlat))
+	expected := `{"home/":1,"home/bin/":1,"home/bin/*":1}` + "\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestRepository_ExportJSON_Nested(t *testing.T) {
+	repo := buildTrieFromTokens(1, "home/", "home/bin/", "home/bin/tar", "home/user/", "root/etc")
+	_ = repo.Inc("home/bin/*")
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportJSON(buffer, "*", JSONNested))
+	expected := `{"home/":{"":1,"bin/":{"":1,"*":1,"tar":1},"user/":1},"root/etc":1}` + "\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestRepository_ImportJSON_RoundTrip(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	for _, format := range []JSONFormat{JSONFlat, JSONNested} {
+		buffer := new(bytes.Buffer)
+		assert.NoError(t, repo.ExportJSON(buffer, "*", format))
+		imported := buildDefaultTrie()
+		result, err := imported.ImportJSON(buffer, format)
+		assert.NoError(t, err)
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, len(repo.GetMap("*")), result.Imported)
+		assert.Empty(t, Diff(repo, imported, "*"))
+		assert.Equal(t, repo.GetValue("*"), imported.GetValue("*"))
+	}
+}
+
+func TestRepository_ImportJSON_ReportsEntryErrors(t *testing.T) {
+	repo := buildTrieFromTokens(1, "exists")
+	document := `{"Exists": 2, "with space": 1, "ok": 3, "text": "x", "fraction": 1.5}`
+	result, err := repo.ImportJSON(strings.NewReader(document), JSONFlat)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Imported)
+	assert.Len(t, result.Errors, 4)
+	assert.Equal(t, 3, repo.GetValue("ok"))
+	assert.Equal(t, 1, repo.GetValue("exists"))
+}
+
+func TestRepository_ImportJSON_Malformed(t *testing.T) {
+	_, err := buildDefaultTrie().ImportJSON(strings.NewReader(`[1, 2]`), JSONFlat)
+	assert.Error(t, err)
+	_, err = buildDefaultTrie().ImportJSON(strings.NewReader(`{"a": `), JSONNested)
+	assert.Error(t, err)
+}
+
+func TestRepository_ExportJSONL(t *testing.T) {
+	repo := buildTrieFromTokens(2, "b", "a")
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.ExportJSONL(buffer, "*"))
+	expected := "{\"key\":\"a\",\"value\":2}\n{\"key\":\"b\",\"value\":2}\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestRepository_ImportJSONL_ReportsLineErrors(t *testing.T) {
+	repo := buildDefaultTrie()
+	lines := strings.Join([]string{
+		`{"key": "a", "value": 1}`,
+		`not json`,
+		``,
+		`{"key": "b"}`,
+		`{"key": "a", "value": 2}`,
+		`{"key": "A*", "value": 4}`,
+	}, "\n")
+	result, err := repo.ImportJSONL(strings.NewReader(lines))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+	lineNumbers := make([]int, 0)
+	for _, importErr := range result.Errors {
+		lineNumbers = append(lineNumbers, importErr.Line)
+	}
+	assert.Equal(t, []int{2, 4, 5}, lineNumbers)
+	assert.Equal(t, map[string]int{"a": 1, "a*": 4}, repo.GetMap("*"))
+	assert.Equal(t, 5, repo.GetValue("a"))
+}
@@ -0,0 +1,109 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/intenvy/memoir/pkg/key"
+)
+
+// the repository is encoded with the binary snapshot format,
+// or as a flat JSON object for json, in both cases the stored
+// (already converted) keys and the pending selector increments
+// are restored as they are, without going through the converter
+// the binary format keeps the deadlines of the keys with a time to live,
+// json does not, the keys decoded from json never expire
+//
+// decoding replaces the content of the repository, a zero Repository
+// can be decoded into and gets the default converter and validator
+// structs should hold a *Repository, so the methods are found by the encoders
+var (
+	_ encoding.BinaryMarshaler   = (*Repository)(nil)
+	_ encoding.BinaryUnmarshaler = (*Repository)(nil)
+	_ gob.GobEncoder             = (*Repository)(nil)
+	_ gob.GobDecoder             = (*Repository)(nil)
+	_ json.Marshaler             = (*Repository)(nil)
+	_ json.Unmarshaler           = (*Repository)(nil)
+)
+
+func (t *Repository) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if err := t.Save(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (t *Repository) UnmarshalBinary(data []byte) error {
+	t.initDefaults()
+	return t.Load(bytes.NewReader(data))
+}
+
+func (t *Repository) GobEncode() ([]byte, error) {
+	return t.MarshalBinary()
+}
+
+func (t *Repository) GobDecode(data []byte) error {
+	return t.UnmarshalBinary(data)
+}
+
+func (t *Repository) MarshalJSON() ([]byte, error) {
+	entries := t.matchingEntries(string(key.SelectorChar))
+	buffer := new(bytes.Buffer)
+	out := bufio.NewWriter(buffer)
+	writeFlatJSON(out, entries)
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (t *Repository) UnmarshalJSON(data []byte) error {
+	var document map[string]int
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	root, size := newRootNode(), 0
+	for path, value := range document {
+		if path == "" {
+			return key.NewErrEmptyKey(path)
+		}
+		if restoreKey(root, path, value) {
+			size++
+		}
+	}
+	t.initDefaults()
+	t.rw.Lock()
+	events := t.replaceRoot(root, size, nil, OperationLoad)
+	t.rw.Unlock()
+	t.watchers.publishAll(events)
+	t.reportEvictions()
+	return nil
+}
+
+// makes a zero Repository usable, before it is decoded into
+func (t *Repository) initDefaults() {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	if t.root == nil {
+		t.root = newRootNode()
+	}
+	if t.converter == nil {
+		t.converter = key.NewConverterPipeline()
+	}
+	if t.validator == nil {
+		t.validator = key.NewValidatorPipeline()
+	}
+	if t.clock == nil {
+		t.clock = clock.System()
+	}
+	if t.expiries == nil {
+		t.expiries = newExpiryIndex()
+	}
+	if t.nodes == 0 {
+		t.nodes = t.root.countNodes()
+	}
+}
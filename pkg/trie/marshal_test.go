@@ -0,0 +1,66 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type cachedCounters struct {
+	Name     string
+	Counters *Repository
+}
+
+func assertSameContent(t *testing.T, expected, actual *Repository) {
+	assert.Equal(t, expected.Size(), actual.Size())
+	assert.Equal(t, expected.GetMap("*"), actual.GetMap("*"))
+	assert.Equal(t, expected.GetValue("home/*"), actual.GetValue("home/*"))
+	assert.Empty(t, Diff(expected, actual, "*"))
+}
+
+func TestRepository_BinaryMarshaler_RoundTrip(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	data, err := repo.MarshalBinary()
+	assert.NoError(t, err)
+	decoded := &Repository{}
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assertSameContent(t, repo, decoded)
+}
+
+func TestRepository_Gob_RoundTrip(t *testing.T) {
+	original := cachedCounters{Name: "sample", Counters: buildSampleSnapshotTrie()}
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, gob.NewEncoder(buffer).Encode(original))
+	var decoded cachedCounters
+	assert.NoError(t, gob.NewDecoder(buffer).Decode(&decoded))
+	assert.Equal(t, original.Name, decoded.Name)
+	assertSameContent(t, original.Counters, decoded.Counters)
+}
+
+func TestRepository_JSON_RoundTrip(t *testing.T) {
+	original := cachedCounters{Name: "sample", Counters: buildSampleSnapshotTrie()}
+	data, err := json.Marshal(original)
+	assert.NoError(t, err)
+	var decoded cachedCounters
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assertSameContent(t, original.Counters, decoded.Counters)
+	_ = decoded.Counters.Inc("home/*")
+	assert.Equal(t, original.Counters.GetValue("home/*")+4, decoded.Counters.GetValue("home/*"))
+}
+
+func TestRepository_JSON_Format(t *testing.T) {
+	repo := buildTrieFromTokens(1, "b", "a")
+	_ = repo.Inc("a*")
+	data, err := json.Marshal(repo)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1,"a*":1,"b":1}`, string(data))
+}
+
+func TestRepository_UnmarshalJSON_Invalid(t *testing.T) {
+	repo := buildTrieFromTokens(1, "a")
+	assert.Error(t, json.Unmarshal([]byte(`{"": 1}`), repo))
+	assert.Error(t, json.Unmarshal([]byte(`{"a": "b"}`), repo))
+	assert.Equal(t, 1, repo.Size())
+}
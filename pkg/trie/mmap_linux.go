@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package trie
+
+import (
+	"os"
+	"syscall"
+)
+
+// maps the file at "path" in memory, read-only and shared
+// with the other processes mapping it
+// returns its content and the function that unmaps it
+func mapFile(path string) ([]byte, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		// an empty file cannot be mapped
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(info.Size())) != info.Size() {
+		return nil, nil, NewErrCorruptSnapshot("file too large to be mapped")
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package trie
+
+import "io/ioutil"
+
+// reads the file at "path" into the heap, where mapping is not supported
+// returns its content and the function that releases it
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
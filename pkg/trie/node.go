@@ -1,40 +1,191 @@
 package trie
 
-import "github.com/intenvy/memoir/pkg/key"
+import "strings"
 
+// node of a radix tree, chains of nodes with a single child
+// are collapsed into the label of the edge leading to the node
+// children are indexed by the first byte of their label
+// and kept ordered by it, see childSet
+//
+// a node only exists where a key ends, where a selector increment
+// is pending, where the tree branches, or at the root
 type trieNode struct {
-	children     map[rune]*trieNode
-	symbol       rune
-	value        int
-	root         bool
-	endOfKey     bool
-	pathFromRoot string
-}
-
-func newTrieNode(symbol rune) *trieNode {
-	return &trieNode{
-		symbol:   symbol,
-		children: make(map[rune]*trieNode),
-	}
+	children childSet
+	// bytes of the edge from the parent to this node, empty for the root
+	label string
+	value int
+	// pending increment of the selector of this prefix, e.g. "home/*"
+	selector    int
+	hasSelector bool
+	root        bool
+	endOfKey    bool
+	// exact counts of a decaying repository, nil otherwise
+	decay *decayedCounts
 }
 
-func (tn *trieNode) forceInitChild(symbol rune) {
-	if _, hasChild := tn.children[symbol]; !hasChild {
-		tn.children[symbol] = newTrieNode(symbol)
-	}
+func newTrieNode(label string) *trieNode {
+	return &trieNode{label: label}
+}
+
+func newRootNode() *trieNode {
+	return &trieNode{root: true}
+}
+
+func (tn *trieNode) child(first byte) (*trieNode, bool) {
+	return tn.children.get(first)
+}
+
+func (tn *trieNode) setChild(child *trieNode) {
+	tn.children.set(child)
 }
 
 func (tn *trieNode) noOfChildren() int {
-	return len(tn.children)
+	return tn.children.len()
 }
 
 func (tn *trieNode) hasChildren() bool {
 	return tn.noOfChildren() > 0
 }
 
-func (tn *trieNode) valueOfSelectorChild() int {
-	if _, hasSelectorChild := tn.children[key.SelectorChar]; hasSelectorChild {
-		return tn.children[key.SelectorChar].value
+// splits the label of "child" after "at" bytes, inserting
+// a new node between this node and "child" and returns it
+func (tn *trieNode) splitChild(child *trieNode, at int) *trieNode {
+	middle := newTrieNode(child.label[:at])
+	// replaced while the label of child still orders it in its parent
+	tn.setChild(middle)
+	child.label = child.label[at:]
+	middle.setChild(child)
+	return middle
+}
+
+// walks "path" down from this node, splitting labels
+// and creating the missing nodes so that a node ends exactly at "path"
+// returns the node at the end of "path"
+func (tn *trieNode) forceWalk(path string) *trieNode {
+	node, _ := tn.forceWalkCounting(path)
+	return node
+}
+
+// same as forceWalk, also returns the number of nodes it created
+func (tn *trieNode) forceWalkCounting(path string) (*trieNode, int) {
+	iter, created := tn, 0
+	for len(path) > 0 {
+		child, hasChild := iter.child(path[0])
+		if !hasChild {
+			// the label is copied so the node does not retain the whole key
+			child = newTrieNode(string(append([]byte(nil), path...)))
+			iter.setChild(child)
+			return child, created + 1
+		}
+		shared := sharedPrefixLength(child.label, path)
+		if shared < len(child.label) {
+			child = iter.splitChild(child, shared)
+			created++
+		}
+		iter = child
+		path = path[shared:]
+	}
+	return iter, created
+}
+
+// walks "path" down from this node without creating nodes
+// returns the node "path" ends on, or the node whose label "path" ends in,
+// the part of that label that is beyond "path", empty if "path" ends on the node
+// and if the whole path has been walked or not
+// when the path is not complete the deepest node reached is returned
+func (tn *trieNode) lazyWalk(path string) (lastNode *trieNode, rest string, completeWalk bool) {
+	iter := tn
+	for len(path) > 0 {
+		child, hasChild := iter.child(path[0])
+		if !hasChild {
+			return iter, "", false
+		}
+		shared := sharedPrefixLength(child.label, path)
+		if shared == len(path) {
+			return child, child.label[shared:], true
+		}
+		if shared < len(child.label) {
+			return iter, "", false
+		}
+		iter = child
+		path = path[shared:]
 	}
-	return 0
+	return iter, "", true
+}
+
+// returns the nodes from this node down to the node "path" ends on exactly,
+// or nil if "path" does not end on a node
+func (tn *trieNode) nodesAlong(path string) []*trieNode {
+	nodes := []*trieNode{tn}
+	iter := tn
+	for len(path) > 0 {
+		child, hasChild := iter.child(path[0])
+		if !hasChild || !strings.HasPrefix(path, child.label) {
+			return nil
+		}
+		nodes = append(nodes, child)
+		iter = child
+		path = path[len(child.label):]
+	}
+	return nodes
+}
+
+// removes the key at "path" below this node, keeping the pending selectors
+// returns the node that held the key, the number of nodes pruned
+// and if the key existed
+func (tn *trieNode) removeKey(path string) (removed *trieNode, pruned int, ok bool) {
+	nodes := tn.nodesAlong(path)
+	if nodes == nil || !nodes[len(nodes)-1].endOfKey {
+		return nil, 0, false
+	}
+	removed = nodes[len(nodes)-1]
+	removed.endOfKey = false
+	removed.value = 0
+	return removed, compact(nodes), true
+}
+
+// restores the shape of the radix tree along "nodes", a path from the root,
+// after the last of them stopped holding a key or a selector
+// nodes that are left with no reason to exist are removed, and a node
+// left with a single child is merged into it, the child being kept
+// so the nodes holding keys are never replaced
+// returns the number of nodes removed from the tree
+func compact(nodes []*trieNode) int {
+	pruned := 0
+	for idx := len(nodes) - 1; idx > 0; idx-- {
+		node, parent := nodes[idx], nodes[idx-1]
+		if node.endOfKey || node.hasSelector {
+			return pruned
+		}
+		switch node.noOfChildren() {
+		case 0:
+			parent.children.remove(node.label[0])
+			pruned++
+		case 1:
+			child := node.sortedChildren()[0]
+			// same first byte, so it takes the place of node in parent
+			child.label = node.label + child.label
+			parent.setChild(child)
+			return pruned + 1
+		default:
+			return pruned
+		}
+	}
+	return pruned
+}
+
+// returns the number of nodes of the tree rooted at this node
+func (tn *trieNode) countNodes() int {
+	count := 1
+	for _, child := range tn.sortedChildren() {
+		count += child.countNodes()
+	}
+	return count
+}
+
+// returns the children of the node
+// ordered by their labels
+// the returned slice must not be modified
+func (tn *trieNode) sortedChildren() []*trieNode {
+	return tn.children.sorted()
 }
@@ -1,34 +1,33 @@
 package trie
 
 import (
-	"github.com/intenvy/memoir/pkg/key"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func buildSampleShallowNode() *trieNode {
-	node := newTrieNode('t')
-	node.forceInitChild('a')
-	node.forceInitChild('b')
+	node := newTrieNode("t")
+	node.setChild(newTrieNode("ab"))
+	node.setChild(newTrieNode("b"))
 	return node
 }
 
 func Test_newTrieNode(t *testing.T) {
 	expected := trieNode{
-		children:     make(map[rune]*trieNode, 0),
-		symbol:       'e',
-		value:        0,
-		root:         false,
-		endOfKey:     false,
-		pathFromRoot: "",
+		label:       "e",
+		value:       0,
+		selector:    0,
+		hasSelector: false,
+		root:        false,
+		endOfKey:    false,
 	}
-	actual := *newTrieNode('e')
+	actual := *newTrieNode("e")
 	assert.Equal(t, expected, actual)
 }
 
-func Test_trieNode_forceInitChild(t *testing.T) {
-	expected := map[rune]*trieNode{'a': newTrieNode('a'), 'b': newTrieNode('b')}
-	actual := buildSampleShallowNode().children
+func Test_trieNode_setChild(t *testing.T) {
+	expected := []*trieNode{newTrieNode("ab"), newTrieNode("b")}
+	actual := buildSampleShallowNode().sortedChildren()
 	assert.Equal(t, expected, actual)
 }
 
@@ -37,7 +36,7 @@ func Test_trieNode_hasChildren_True(t *testing.T) {
 }
 
 func Test_trieNode_hasChildren_False(t *testing.T) {
-	assert.False(t, newTrieNode('a').hasChildren())
+	assert.False(t, newTrieNode("a").hasChildren())
 }
 
 func Test_trieNode_noOfChildren(t *testing.T) {
@@ -46,17 +45,110 @@ func Test_trieNode_noOfChildren(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
-func Test_trieNode_valueOfSelectorChild_NoSelectorChild(t *testing.T) {
-	expected := 0
-	actual := buildSampleShallowNode().valueOfSelectorChild()
-	assert.Equal(t, expected, actual)
+func Test_trieNode_splitChild(t *testing.T) {
+	node := newRootNode()
+	child := newTrieNode("abcd")
+	node.setChild(child)
+	middle := node.splitChild(child, 2)
+	assert.Equal(t, "ab", middle.label)
+	assert.Equal(t, "cd", child.label)
+	assert.Equal(t, middle, childOf(node, 'a'))
+	assert.Equal(t, child, childOf(middle, 'c'))
+}
+
+func Test_trieNode_forceWalk_CreatesAndSplits(t *testing.T) {
+	root := newRootNode()
+	abcd := root.forceWalk("abcd")
+	assert.Equal(t, "abcd", abcd.label)
+	assert.Equal(t, abcd, root.forceWalk("abcd"))
+	abx := root.forceWalk("abx")
+	assert.Equal(t, "x", abx.label)
+	assert.Equal(t, "cd", abcd.label)
+	ab := childOf(root, 'a')
+	assert.Equal(t, "ab", ab.label)
+	assert.Equal(t, 2, ab.noOfChildren())
+}
+
+func Test_trieNode_forceWalkCounting(t *testing.T) {
+	root := newRootNode()
+	_, created := root.forceWalkCounting("abcd")
+	assert.Equal(t, 1, created)
+	_, created = root.forceWalkCounting("abcd")
+	assert.Equal(t, 0, created)
+	// splits "abcd" and adds "x"
+	_, created = root.forceWalkCounting("abx")
+	assert.Equal(t, 2, created)
+	assert.Equal(t, 4, root.countNodes())
+}
+
+func Test_trieNode_lazyWalk(t *testing.T) {
+	root := newRootNode()
+	abcd := root.forceWalk("abcd")
+	node, rest, completeWalk := root.lazyWalk("ab")
+	assert.Equal(t, abcd, node)
+	assert.Equal(t, "cd", rest)
+	assert.True(t, completeWalk)
+	node, rest, completeWalk = root.lazyWalk("abcd")
+	assert.Equal(t, abcd, node)
+	assert.Equal(t, "", rest)
+	assert.True(t, completeWalk)
+	node, _, completeWalk = root.lazyWalk("abx")
+	assert.Equal(t, root, node)
+	assert.False(t, completeWalk)
+	node, _, completeWalk = root.lazyWalk("abcde")
+	assert.Equal(t, abcd, node)
+	assert.False(t, completeWalk)
 }
 
-func Test_trieNode_valueOfSelectorChild_WithSelectorChild(t *testing.T) {
+func Test_trieNode_sortedChildren(t *testing.T) {
 	node := buildSampleShallowNode()
-	node.forceInitChild(key.SelectorChar)
-	node.children[key.SelectorChar].value = 10
-	expected := 10
-	actual := node.valueOfSelectorChild()
+	node.setChild(newTrieNode("0"))
+	expected := []string{"0", "ab", "b"}
+	actual := make([]string, 0)
+	for _, child := range node.sortedChildren() {
+		actual = append(actual, child.label)
+	}
 	assert.Equal(t, expected, actual)
 }
+
+func childOf(node *trieNode, first byte) *trieNode {
+	child, _ := node.child(first)
+	return child
+}
+
+func Test_trieNode_removeKey_PrunesAndMerges(t *testing.T) {
+	root := newRootNode()
+	for _, path := range []string{"abc", "abd", "x"} {
+		root.forceWalk(path).endOfKey = true
+	}
+	kept := root.forceWalk("abd")
+	removed, pruned, ok := root.removeKey("abc")
+	assert.True(t, ok)
+	assert.False(t, removed.endOfKey)
+	assert.Equal(t, 2, pruned)
+	// "ab" is left with a single child, which takes its place
+	assert.Same(t, kept, childOf(root, 'a'))
+	assert.Equal(t, "abd", kept.label)
+	assert.Equal(t, 2, root.noOfChildren())
+
+	_, _, ok = root.removeKey("ab")
+	assert.False(t, ok)
+	_, _, ok = root.removeKey("abd")
+	assert.True(t, ok)
+	_, _, ok = root.removeKey("x")
+	assert.True(t, ok)
+	assert.False(t, root.hasChildren())
+	assert.Equal(t, 1, root.countNodes())
+}
+
+func Test_trieNode_removeKey_KeepsSelectors(t *testing.T) {
+	root := newRootNode()
+	root.forceWalk("abc").endOfKey = true
+	root.forceWalk("ab").hasSelector = true
+	_, _, ok := root.removeKey("abc")
+	assert.True(t, ok)
+	selector := childOf(root, 'a')
+	assert.Equal(t, "ab", selector.label)
+	assert.True(t, selector.hasSelector)
+	assert.False(t, selector.hasChildren())
+}
@@ -0,0 +1,95 @@
+package trie
+
+import (
+	"expvar"
+	"time"
+)
+
+// names of the operations reported to observers
+const (
+	OperationInsert   = "Insert"
+	OperationGetMap   = "GetMap"
+	OperationGetValue = "GetValue"
+	OperationInc      = "Inc"
+	OperationIncBy    = "IncBy"
+	OperationIncAt    = "IncAt"
+	OperationContains = "Contains"
+	OperationReset    = "Reset"
+	OperationClear    = "ClearSelectorIncrement"
+)
+
+// a single call to the repository, as reported to observers
+type Operation struct {
+	Name    string
+	Pattern string
+	// time spent in the call, waiting for the lock included
+	Duration time.Duration
+	// number of entries returned by GetMap, 1 if Contains found a match
+	// or if Insert, Inc and ClearSelectorIncrement changed a key,
+	// the number of keys matched by Reset, 0 otherwise
+	ResultSize int
+	Err        error
+}
+
+// receives every operation of the repositories it is added to
+// it is called after the lock of the repository is released,
+// from the goroutine of the caller, so it must be safe for concurrent use
+// and should return quickly
+type Observer interface {
+	Observe(operation Operation)
+}
+
+// adds an observer to the repository
+// observers must be added before the repository is shared
+func (t *Repository) AddObserver(observer Observer) *Repository {
+	t.observers = append(t.observers, observer)
+	return t
+}
+
+func (t *Repository) notify(name, pattern string, start time.Time, resultSize int, err error) {
+	operation := Operation{
+		Name:       name,
+		Pattern:    pattern,
+		Duration:   time.Since(start),
+		ResultSize: resultSize,
+		Err:        err,
+	}
+	for _, observer := range t.observers {
+		observer.Observe(operation)
+	}
+}
+
+func changedKeys(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// observer publishing counters per operation through expvar,
+// e.g. "GetMap.count", "GetMap.errors", "GetMap.nanoseconds" and "GetMap.results"
+type ExpvarObserver struct {
+	vars *expvar.Map
+}
+
+var _ Observer = (*ExpvarObserver)(nil)
+
+// publishes the counters under "name"
+// like expvar.NewMap, it panics if "name" is already published
+func NewExpvarObserver(name string) *ExpvarObserver {
+	return &ExpvarObserver{vars: expvar.NewMap(name)}
+}
+
+func (o *ExpvarObserver) Observe(operation Operation) {
+	o.vars.Add(operation.Name+".count", 1)
+	o.vars.Add(operation.Name+".nanoseconds", int64(operation.Duration))
+	o.vars.Add(operation.Name+".results", int64(operation.ResultSize))
+	if operation.Err != nil {
+		o.vars.Add(operation.Name+".errors", 1)
+	}
+}
+
+// returns the counters, e.g. to be published under another name
+func (o *ExpvarObserver) Vars() *expvar.Map {
+	return o.vars
+}
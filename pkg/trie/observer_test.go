@@ -0,0 +1,81 @@
+package trie
+
+import (
+	"expvar"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+type recordingObserver struct {
+	mutex      sync.Mutex
+	operations []Operation
+}
+
+func (o *recordingObserver) Observe(operation Operation) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.operations = append(o.operations, operation)
+}
+
+func TestRepository_AddObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	repo := buildDefaultTrie().AddObserver(observer)
+	_ = repo.Insert("home/a", 1)
+	_ = repo.Insert("home/a", 1)
+	_ = repo.Inc("home/*")
+	_ = repo.GetMap("home/*")
+	_ = repo.GetValue("home/a")
+	_ = repo.Contains("work/*")
+
+	names := make([]string, 0)
+	for _, operation := range observer.operations {
+		names = append(names, operation.Name)
+		assert.GreaterOrEqual(t, int64(operation.Duration), int64(0))
+	}
+	assert.Equal(t, []string{
+		OperationInsert, OperationInsert, OperationInc,
+		OperationGetMap, OperationGetValue, OperationContains,
+	}, names)
+	assert.Equal(t, "home/a", observer.operations[0].Pattern)
+	assert.Equal(t, 1, observer.operations[0].ResultSize)
+	assert.NoError(t, observer.operations[0].Err)
+	assert.IsType(t, &key.ErrKeyAlreadyExists{}, observer.operations[1].Err)
+	assert.Equal(t, 0, observer.operations[1].ResultSize)
+	assert.IsType(t, &key.ErrKeyNotFound{}, observer.operations[2].Err)
+	assert.Equal(t, 2, observer.operations[3].ResultSize)
+	assert.Equal(t, 0, observer.operations[5].ResultSize)
+}
+
+func TestRepository_AddObserver_CalledAfterUnlock(t *testing.T) {
+	repo := New()
+	// an observer reading the repository would deadlock under the lock
+	repo.AddObserver(observerFunc(func(operation Operation) {
+		if operation.Name == OperationInsert {
+			repo.GetValue("a")
+		}
+	}))
+	assert.NoError(t, repo.Insert("a", 1))
+}
+
+type observerFunc func(operation Operation)
+
+func (f observerFunc) Observe(operation Operation) {
+	f(operation)
+}
+
+func TestExpvarObserver_Observe(t *testing.T) {
+	observer := NewExpvarObserver("memoir_test_observer")
+	repo := New().AddObserver(observer)
+	_ = repo.Insert("a", 1)
+	_ = repo.Insert("b", 1)
+	_ = repo.Insert("a", 1)
+	_ = repo.GetMap("*")
+	assert.Same(t, observer.Vars(), expvar.Get("memoir_test_observer"))
+	assert.Equal(t, "3", observer.Vars().Get("Insert.count").String())
+	assert.Equal(t, "1", observer.Vars().Get("Insert.errors").String())
+	assert.Equal(t, "2", observer.Vars().Get("Insert.results").String())
+	assert.Equal(t, "2", observer.Vars().Get("GetMap.results").String())
+	assert.Nil(t, observer.Vars().Get("GetMap.errors"))
+}
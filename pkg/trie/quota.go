@@ -0,0 +1,81 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"strings"
+)
+
+// limits the sum of the keys matching the selector "pattern",
+// as returned by GetValue, e.g. "tenants/acme/*"
+// Inc and IncBy fail with ErrQuotaExceeded rather than take the sum
+// of any prefix of the key they change beyond its limit,
+// Insert and the imports are not limited
+// a "max" that is not positive removes the limit of the prefix
+// returns an error if "pattern" is not a selector
+func (t *Repository) SetLimit(pattern string, max int) error {
+	entry := key.New(pattern, t.converter, t.validator)
+	if !entry.IsSelector() {
+		return NewErrNotSelector(string(*entry))
+	}
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	if max <= 0 {
+		if t.limits != nil {
+			t.limits.removeKey(entryPath(entry))
+		}
+		return nil
+	}
+	if t.limits == nil {
+		t.limits = newRootNode()
+	}
+	node := t.limits.forceWalk(entryPath(entry))
+	node.endOfKey = true
+	node.value = max
+	return nil
+}
+
+// returns the number of keys of the sub-trie rooted at "tn"
+func countKeys(tn *trieNode) int {
+	count := 0
+	if tn.endOfKey {
+		count++
+	}
+	for _, child := range tn.sortedChildren() {
+		count += countKeys(child)
+	}
+	return count
+}
+
+// returns the sum of the keys starting with "prefix", as GetValue does
+// must hold the lock
+func (t *Repository) prefixSum(prefix string) int {
+	node, _, completeWalk := t.root.lazyWalk(prefix)
+	if !completeWalk {
+		return 0
+	}
+	return dfsGetValue(node, t.currentCounts(), 0)
+}
+
+// checks the limits of the prefixes of "path", shortest first,
+// against an increment of "delta" of the keys starting with "path"
+// returns ErrQuotaExceeded for the first limit it would exceed
+// must hold the write lock
+func (t *Repository) checkLimits(path string, delta int) error {
+	if t.limits == nil || delta <= 0 {
+		return nil
+	}
+	node, depth := t.limits, 0
+	for {
+		if node.endOfKey && t.prefixSum(path[:depth])+delta > node.value {
+			return NewErrQuotaExceeded(path[:depth]+string(key.SelectorChar), node.value)
+		}
+		if depth == len(path) {
+			return nil
+		}
+		child, found := node.child(path[depth])
+		if !found || !strings.HasPrefix(path[depth:], child.label) {
+			return nil
+		}
+		node, depth = child, depth+len(child.label)
+	}
+}
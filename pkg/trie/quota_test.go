@@ -0,0 +1,88 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func buildTenantTrie() *Repository {
+	repo := buildDefaultTrie()
+	for _, k := range []string{"tenants/acme/api", "tenants/acme/jobs", "tenants/globex/api"} {
+		_ = repo.Insert(k, 0)
+	}
+	return repo
+}
+
+func TestRepository_SetLimit(t *testing.T) {
+	repo := buildTenantTrie()
+	assert.NoError(t, repo.SetLimit("tenants/acme/*", 10))
+	assert.NoError(t, repo.IncBy("tenants/acme/api", 6))
+	assert.NoError(t, repo.IncBy("tenants/acme/jobs", 4))
+
+	err := repo.Inc("tenants/acme/api")
+	assert.IsType(t, &ErrQuotaExceeded{}, err)
+	assert.Equal(t, "tenants/acme/*", err.(*ErrQuotaExceeded).Prefix)
+	assert.EqualError(t, err, `quota of "tenants/acme/*" exceeded: limit is 10`)
+	// nothing is applied and other tenants are not limited
+	assert.Equal(t, 10, repo.GetValue("tenants/acme/*"))
+	assert.NoError(t, repo.IncBy("tenants/globex/api", 100))
+	// decrements are never refused
+	assert.NoError(t, repo.IncBy("tenants/acme/api", -1))
+	assert.NoError(t, repo.Inc("tenants/acme/jobs"))
+
+	// a limit that is not positive removes it
+	assert.NoError(t, repo.SetLimit("tenants/acme/*", 0))
+	assert.NoError(t, repo.IncBy("tenants/acme/api", 100))
+}
+
+func TestRepository_SetLimit_Ancestors(t *testing.T) {
+	repo := buildTenantTrie()
+	assert.NoError(t, repo.SetLimit("tenants/*", 20))
+	assert.NoError(t, repo.SetLimit("tenants/acme/*", 15))
+	assert.NoError(t, repo.IncBy("tenants/globex/api", 10))
+	// within the limit of acme, beyond the limit of all the tenants
+	err := repo.IncBy("tenants/acme/api", 11)
+	assert.Equal(t, NewErrQuotaExceeded("tenants/*", 20), err)
+	err = repo.IncBy("tenants/acme/api", 16)
+	assert.Equal(t, NewErrQuotaExceeded("tenants/*", 20), err)
+	assert.NoError(t, repo.SetLimit("*", 100))
+	assert.NoError(t, repo.SetLimit("tenants/*", 100))
+	err = repo.IncBy("tenants/acme/api", 16)
+	assert.Equal(t, NewErrQuotaExceeded("tenants/acme/*", 15), err)
+	// a limit may end inside a label
+	assert.NoError(t, repo.SetLimit("tenants/ac*", 5))
+	err = repo.IncBy("tenants/acme/jobs", 6)
+	assert.Equal(t, NewErrQuotaExceeded("tenants/ac*", 5), err)
+	assert.Equal(t, 10, repo.GetValue("*"))
+}
+
+func TestRepository_SetLimit_SelectorIncrements(t *testing.T) {
+	repo := buildTenantTrie()
+	assert.NoError(t, repo.SetLimit("tenants/acme/*", 5))
+	// counts once per key of the prefix
+	// applied even though the prefix is not a key
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.IncBy("tenants/acme/*", 2))
+	assert.Equal(t, 4, repo.GetValue("tenants/acme/*"))
+	err := repo.Inc("tenants/acme/*")
+	assert.Equal(t, NewErrQuotaExceeded("tenants/acme/*", 5), err)
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.Inc("tenants/acme/j*"))
+	assert.Equal(t, 5, repo.GetValue("tenants/acme/*"))
+	assert.Equal(t, NewErrQuotaExceeded("tenants/acme/*", 5), repo.Inc("tenants/acme/jobs"))
+}
+
+func TestRepository_SetLimit_NotSelector(t *testing.T) {
+	repo := buildTenantTrie()
+	assert.IsType(t, &ErrNotSelector{}, repo.SetLimit("tenants/acme", 10))
+	assert.NoError(t, repo.Inc("tenants/acme/api"))
+}
+
+func TestRepository_IncBy(t *testing.T) {
+	repo := buildTenantTrie()
+	assert.NoError(t, repo.IncBy("tenants/acme/api", 5))
+	assert.NoError(t, repo.IncBy("tenants/acme/api*", 2))
+	assert.Equal(t, 7, repo.GetValue("tenants/acme/api"))
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.IncBy("tenants/*", 2))
+	assert.Equal(t, 13, repo.GetValue("tenants/*"))
+	assert.IsType(t, &key.ErrKeyNotFound{}, repo.IncBy("tenants/initech/api", 1))
+}
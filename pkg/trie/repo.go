@@ -3,8 +3,11 @@ package trie
 import (
 	"fmt"
 	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/clock"
 	"github.com/intenvy/memoir/pkg/key"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Repository struct {
@@ -13,14 +16,31 @@ type Repository struct {
 	size      int
 	converter key.Converter
 	validator key.Validator
+	observers []Observer
+	watchers  watcherSet
+	clock     clock.Clock
+	expiries  *expiryIndex
+	// nodes of the radix tree, the root included
+	nodes int
+	// limits of the repository, nil if it has none
+	capacity *capacityIndex
+	// quotas of the prefixes, stored as keys, nil if there is none
+	limits *trieNode
+	// counters per interval of the keys, nil if it is not a time series
+	series *seriesIndex
+	// time for the values to decay by half, zero if they do not decay
+	halfLife time.Duration
 }
 
 func New() *Repository {
 	return &Repository{
 		size:      0,
-		root:      &trieNode{root: true, children: make(map[rune]*trieNode)},
+		root:      newRootNode(),
+		nodes:     1,
 		converter: key.NewConverterPipeline(),
 		validator: key.NewValidatorPipeline(),
+		clock:     clock.System(),
+		expiries:  newExpiryIndex(),
 	}
 }
 
@@ -36,181 +56,495 @@ func (t *Repository) AddValidator(validator key.Validator) *Repository {
 	return t
 }
 
+// returns the path the entry walks along
+// a selector only walks until the rune before the selector
+func entryPath(entry *key.Key) string {
+	if entry.IsSelector() {
+		return string(*entry)[:entry.Size()-1]
+	}
+	return string(*entry)
+}
+
 // walks the trie along the entry characters
 // if a node is not present, it force creates it
+// and if the path ends inside a label, the label is split
 // if the entry is a selector, then it only walks
 // until one rune is left
 // returns the node it ended up on
-func (t *Repository) forceWalk(entry *key.Key) (lastNode *trieNode, pathExists bool) {
-	var (
-		iter       = t.root
-		isSelector = entry.IsSelector()
-		pathSize   = entry.Size()
-	)
-	for idx, symbol := range *entry {
-		if isSelector && idx == pathSize-1 {
-			break
+// and the number of nodes it created
+func (t *Repository) forceWalk(entry *key.Key) (lastNode *trieNode, pathExists bool, created int) {
+	node, created := t.root.forceWalkCounting(entryPath(entry))
+	t.nodes += created
+	return node, node.endOfKey, created
+}
+
+// removes the key at "path" and the nodes it leaves useless
+// returns if the key existed
+// must hold the write lock
+func (t *Repository) removeKeyLocked(path string) bool {
+	node, pruned, removed := t.root.removeKey(path)
+	if !removed {
+		return false
+	}
+	t.size--
+	t.nodes -= pruned
+	t.expiries.clear(node)
+	if t.capacity != nil {
+		t.capacity.forget(node)
+	}
+	if t.series != nil {
+		delete(t.series.keys, node)
+	}
+	return true
+}
+
+// replaces the content of the repository with the tree rooted at "root"
+// and the deadlines of its keys, by key, which may be nil
+// the keys beyond the capacity of the repository are evicted
+// returns the changes of the keys, reported as "op", if anyone watches them
+// must hold the write lock
+func (t *Repository) replaceRoot(root *trieNode, size int, deadlines map[string]time.Time, op string) []ChangeEvent {
+	var events []ChangeEvent
+	if t.watchers.active() {
+		events = changesBetween(t.root, t.currentCounts(), root, op)
+	}
+	t.root = root
+	t.size = size
+	t.nodes = root.countNodes()
+	t.expiries.reset()
+	for path, deadline := range deadlines {
+		if nodes := root.nodesAlong(path); nodes != nil && nodes[len(nodes)-1].endOfKey {
+			t.expiries.set(nodes[len(nodes)-1], path, deadline)
 		}
-		iter.forceInitChild(symbol)
-		iter = iter.children[symbol]
 	}
-	return iter, iter.endOfKey
+	if t.halfLife > 0 {
+		resetDecay(root, t.clock.Now())
+	}
+	if t.series != nil {
+		t.series.reset()
+	}
+	if t.capacity != nil {
+		t.capacity.reset()
+		t.capacity.addAll(root, nil)
+		t.enforceCapacity(nil)
+	}
+	return events
 }
 
 // walks the trie along the entry characters
 // if a node is not present, it returns
 // if the entry is a selector, then it only walks until one rune is left
-// returns the last node of the walk
+// returns the last node of the walk, which is the node whose label
+// the path ends in when it does not end exactly on a node
 // and if the path exists or not
 // and if the whole path has been walked or not
 func (t *Repository) lazyWalk(entry *key.Key) (lastNode *trieNode, pathExists bool, completeWalk bool) {
-	var (
-		iter       = t.root
-		isSelector = entry.IsSelector()
-		pathSize   = entry.Size()
-	)
-	for idx, symbol := range *entry {
-		if isSelector && idx == pathSize-1 {
-			break
-		}
-		if _, hasChild := iter.children[symbol]; !hasChild {
-			return iter, false, false
-		}
-		iter = iter.children[symbol]
-	}
-	return iter, iter.endOfKey, true
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	return node, completeWalk && rest == "" && node.endOfKey, completeWalk
 }
 
 func (t *Repository) Insert(pattern string, value int) error {
+	if len(t.observers) == 0 {
+		return t.insert(pattern, value)
+	}
+	start := time.Now()
+	err := t.insert(pattern, value)
+	t.notify(OperationInsert, pattern, start, changedKeys(err), err)
+	return err
+}
+
+func (t *Repository) insert(pattern string, value int) error {
+	t.expireDue()
 	entry := key.New(pattern, t.converter, t.validator)
 	if entry.IsSelector() {
 		return key.NewErrSelectorKeyNotAllowed(*entry)
 	}
 	// entry is a raw key
+	err := t.insertEntry(entry, value, 0)
+	if err == nil {
+		// watchers are notified once the lock is released
+		t.watchers.publish(ChangeEvent{Key: string(*entry), New: value, Op: OperationInsert})
+	}
+	t.reportEvictions()
+	return err
+}
+
+// inserts the entry, which expires after "ttl" if it is positive
+func (t *Repository) insertEntry(entry *key.Key, value int, ttl time.Duration) error {
 	t.rw.Lock()
 	defer t.rw.Unlock()
-	node, keyExists := t.forceWalk(entry)
+	node, keyExists, created := t.forceWalk(entry)
 	if !keyExists {
+		if t.rejectsGrowth(created, true) {
+			t.rollbackWalk(string(*entry))
+			return NewErrCapacityExceeded(string(*entry))
+		}
 		// entry is a new key
 		t.size++
 		node.endOfKey = true
-		node.pathFromRoot = string(*entry)
 		node.value = value
+		if t.halfLife > 0 {
+			t.setDecayed(node, false)
+		}
+		t.setTTL(node, string(*entry), ttl)
+		if t.capacity != nil {
+			t.capacity.add(node, string(*entry))
+			t.enforceCapacity(node)
+		}
 		return nil
 	}
 	// the key already existed
 	return key.NewErrKeyAlreadyExist(*entry)
 }
 
-func dfsFillMap(tn *trieNode, out map[string]int) {
-	if tn.hasChildren() {
-		for _, node := range tn.children {
-			if node.endOfKey {
-				out[node.pathFromRoot] = node.value
-			}
-			dfsFillMap(node, out)
+// visits every key of the sub-trie rooted at "tn" in lexicographic order
+// "path" holds the bytes leading to "tn" and is extended along the way
+// pending selector increments are visited as keys ending with the selector
+// the values are read by "counts"
+func dfsVisitKeys(tn *trieNode, path []byte, counts nodeCounts, visit func(path []byte, value int)) {
+	value, selector := counts(tn)
+	if tn.endOfKey {
+		visit(path, value)
+	}
+	selectorVisited := !tn.hasSelector
+	for _, child := range tn.sortedChildren() {
+		if !selectorVisited && child.label[0] >= key.SelectorChar {
+			visit(append(path, key.SelectorChar), selector)
+			selectorVisited = true
+		}
+		dfsVisitKeys(child, append(path, child.label...), counts, visit)
+	}
+	if !selectorVisited {
+		visit(append(path, key.SelectorChar), selector)
+	}
+}
+
+// visits every key matching "entry" in lexicographic order
+// a raw entry only matches its own key, a selector matches
+// every key of the sub-trie of its prefix, pending increments included
+func (t *Repository) visitMatches(entry *key.Key, visit func(path []byte, value int)) {
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if !completeWalk {
+		return
+	}
+	counts := t.currentCounts()
+	if entry.IsRaw() {
+		if rest == "" && node.endOfKey {
+			value, _ := counts(node)
+			visit([]byte(*entry), value)
 		}
+		return
+	}
+	// the prefix may end inside the label of node
+	dfsVisitKeys(node, []byte(entryPath(entry)+rest), counts, visit)
+}
+
+// walks "path" from "root", creating the missing nodes and stores "value"
+// on the node at the end of "path", "path" is not converted nor validated
+// a path ending with the selector restores a pending selector increment
+// returns true if a new raw key has been added
+func restoreKey(root *trieNode, path string, value int) bool {
+	if len(path) > 0 && path[len(path)-1] == key.SelectorChar {
+		node := root.forceWalk(path[:len(path)-1])
+		node.selector = value
+		node.hasSelector = true
+		return false
+	}
+	node := root.forceWalk(path)
+	isNew := !node.endOfKey
+	node.endOfKey = true
+	node.value = value
+	return isNew
+}
+
+// reads the value and the pending selector increment of a node,
+// e.g. decayed up to now, or counted over a time range
+type nodeCounts func(tn *trieNode) (value, selector int)
+
+// reads the counts of a node as they are stored
+func storedCounts(tn *trieNode) (int, int) {
+	return tn.value, tn.selector
+}
+
+// visits the keys of the sub-trie rooted at "tn" and its pending
+// selector increments, with their counts read by "counts"
+// the keys are rebuilt from the labels, "path" holds the bytes leading to "tn"
+// the path of a selector increment ends with the selector, e.g. "home/*"
+func dfsVisitCounts(tn *trieNode, path []byte, counts nodeCounts, visit func(path []byte, count int, selector bool)) {
+	value, selector := counts(tn)
+	if tn.endOfKey {
+		visit(path, value, false)
+	}
+	if tn.hasSelector {
+		visit(append(path, key.SelectorChar), selector, true)
+	}
+	for _, node := range tn.sortedChildren() {
+		dfsVisitCounts(node, append(path, node.label...), counts, visit)
 	}
 }
 
+// fills "out" with the keys of the sub-trie rooted at "tn"
+// and its pending selector increments
+func dfsFillMap(tn *trieNode, path []byte, counts nodeCounts, out map[string]int) {
+	dfsVisitCounts(tn, path, counts, func(path []byte, count int, _ bool) {
+		out[string(path)] = count
+	})
+}
+
 func (t *Repository) GetMap(pattern string) map[string]int {
+	if len(t.observers) == 0 {
+		return t.getMap(pattern)
+	}
+	start := time.Now()
+	results := t.getMap(pattern)
+	t.notify(OperationGetMap, pattern, start, len(results), nil)
+	return results
+}
+
+func (t *Repository) getMap(pattern string) map[string]int {
+	t.expireDue()
 	entry := key.New(pattern, t.converter, t.validator)
-	results := make(map[string]int)
 	t.rw.RLock()
 	defer t.rw.RUnlock()
-	node, pathExists, completeWalk := t.lazyWalk(entry)
-	if !completeWalk {
-		return make(map[string]int)
+	results, node := t.mapOf(entry, t.currentCounts())
+	if node != nil && t.capacity != nil {
+		t.capacity.touch(node)
 	}
-	if pathExists {
-		results[node.pathFromRoot] = node.value
+	return results
+}
+
+// returns the keys matching "entry" with their counts read by "counts",
+// and the node of the key read by a raw "entry", nil if there is none
+// must hold the lock
+func (t *Repository) mapOf(entry *key.Key, counts nodeCounts) (map[string]int, *trieNode) {
+	results := make(map[string]int)
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if !completeWalk {
+		return results, nil
 	}
 	if entry.IsSelector() {
-		dfsFillMap(node, results)
+		// the prefix may end inside the label of node
+		dfsFillMap(node, []byte(entryPath(entry)+rest), counts, results)
+	} else if rest == "" && node.endOfKey {
+		results[string(*entry)], _ = counts(node)
+		return results, node
 	}
-	return results
+	return results, nil
 }
 
-func dfsGetValue(tn *trieNode, carry int) int {
+func dfsGetValue(tn *trieNode, counts nodeCounts, carry int) int {
 	result := 0
-	carry += tn.valueOfSelectorChild()
+	value, selector := counts(tn)
+	carry += selector
 	if tn.endOfKey {
-		result += tn.value + carry
+		result += value + carry
 	}
-	for _, child := range tn.children {
-		if child.symbol != key.SelectorChar {
-			result += dfsGetValue(child, carry)
-		}
+	for _, child := range tn.sortedChildren() {
+		result += dfsGetValue(child, counts, carry)
 	}
 	return result
 }
 
 func (t *Repository) GetValue(pattern string) int {
+	if len(t.observers) == 0 {
+		return t.getValue(pattern)
+	}
+	start := time.Now()
+	value := t.getValue(pattern)
+	t.notify(OperationGetValue, pattern, start, 0, nil)
+	return value
+}
+
+func (t *Repository) getValue(pattern string) int {
+	t.expireDue()
 	entry := key.New(pattern, t.converter, t.validator)
 	t.rw.RLock()
 	defer t.rw.RUnlock()
-	node, _, completeWalk := t.lazyWalk(entry)
+	value, node := t.valueOf(entry, t.currentCounts())
+	if node != nil && t.capacity != nil {
+		t.capacity.touch(node)
+	}
+	return value
+}
+
+// returns the value of "entry" with the counts read by "counts",
+// and the node of the key read by a raw "entry", nil if there is none
+// must hold the lock
+func (t *Repository) valueOf(entry *key.Key, counts nodeCounts) (int, *trieNode) {
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
 	if !completeWalk {
-		return 0
+		return 0, nil
 	}
 	if entry.IsSelector() {
-		return dfsGetValue(node, 0)
-	} else {
-		return node.valueOfSelectorChild() + node.value
+		return dfsGetValue(node, counts, 0), nil
+	} else if rest != "" {
+		return 0, nil
+	}
+	value, selector := counts(node)
+	if !node.endOfKey {
+		return selector, nil
 	}
+	return value + selector, node
 }
 
 func (t *Repository) Inc(pattern string) error {
+	if len(t.observers) == 0 {
+		return t.inc(pattern, 1, time.Time{}, OperationInc)
+	}
+	start := time.Now()
+	err := t.inc(pattern, 1, time.Time{}, OperationInc)
+	t.notify(OperationInc, pattern, start, changedKeys(err), err)
+	return err
+}
+
+// same as Inc, adding "delta" rather than one
+func (t *Repository) IncBy(pattern string, delta int) error {
+	if len(t.observers) == 0 {
+		return t.inc(pattern, delta, time.Time{}, OperationIncBy)
+	}
+	start := time.Now()
+	err := t.inc(pattern, delta, time.Time{}, OperationIncBy)
+	t.notify(OperationIncBy, pattern, start, changedKeys(err), err)
+	return err
+}
+
+// a zero "at" counts the increment now in the time series, if any
+func (t *Repository) inc(pattern string, delta int, at time.Time, operation string) error {
+	t.expireDue()
 	entry := key.New(pattern, t.converter, t.validator)
+	old, applied, err := t.incEntry(entry, delta, at)
+	if applied {
+		// watchers are notified once the lock is released
+		t.watchers.publish(ChangeEvent{Key: string(*entry), Old: old, New: old + delta, Op: operation})
+	}
+	t.reportEvictions()
+	return err
+}
+
+// adds "delta" to the entry and returns the value it had before
+// and if the increment has been applied, which a selector
+// increment is even when its prefix is not a key
+// the increment is counted at "at" in the time series, if any
+func (t *Repository) incEntry(entry *key.Key, delta int, at time.Time) (old int, applied bool, err error) {
 	t.rw.Lock()
 	defer t.rw.Unlock()
 	node, pathExists, completeWalk := t.lazyWalk(entry)
 	if !completeWalk {
-		return key.NewErrKeyNotFound(*entry)
+		return 0, false, key.NewErrKeyNotFound(*entry)
 	}
 	if entry.IsSelector() {
-		//       / * increment here
-		//  node - child
-		//       \ child
-		node.forceInitChild(key.SelectorChar)
-		child := node.children[key.SelectorChar]
-		child.value++
-		child.endOfKey = true
-		child.pathFromRoot = pattern
+		//        * increment here
+		//  node  - child
+		//        \ child
+		if err := t.checkLimits(entryPath(entry), delta*countKeys(node)); err != nil {
+			return 0, false, err
+		}
+		var created int
+		node, pathExists, created = t.forceWalk(entry)
+		if t.rejectsGrowth(created, false) {
+			t.rollbackWalk(entryPath(entry))
+			return 0, false, NewErrCapacityExceeded(string(*entry))
+		}
+		if t.halfLife > 0 {
+			old = t.decayedInc(node, true, delta)
+		} else {
+			old = node.selector
+			node.selector += delta
+		}
+		node.hasSelector = true
+		if t.series != nil {
+			t.recordSeries(t.series.selectors, node, delta, at)
+		}
+		if created > 0 {
+			t.enforceCapacity(nil)
+		}
 		if !pathExists {
 			// no child, no path
-			return key.NewErrKeyNotFound(*entry)
+			return old, true, key.NewErrKeyNotFound(*entry)
 		}
-		return nil
+		return old, true, nil
 	} else if !pathExists {
-		return key.NewErrKeyNotFound(*entry)
+		return 0, false, key.NewErrKeyNotFound(*entry)
+	}
+	if err := t.checkLimits(string(*entry), delta); err != nil {
+		return 0, false, err
+	}
+	if t.halfLife > 0 {
+		old = t.decayedInc(node, false, delta)
 	} else {
-		node.value++
+		old = node.value
+		node.value += delta
 	}
-	return nil
+	if t.series != nil {
+		t.recordSeries(t.series.keys, node, delta, at)
+	}
+	if t.capacity != nil {
+		t.capacity.touch(node)
+	}
+	return old, true, nil
 }
 
 func (t *Repository) Contains(pattern string) bool {
+	if len(t.observers) == 0 {
+		return t.contains(pattern)
+	}
+	start := time.Now()
+	found := t.contains(pattern)
+	resultSize := 0
+	if found {
+		resultSize = 1
+	}
+	t.notify(OperationContains, pattern, start, resultSize, nil)
+	return found
+}
+
+func (t *Repository) contains(pattern string) bool {
+	t.expireDue()
 	entry := key.New(pattern, t.converter, t.validator)
 	t.rw.RLock()
 	defer t.rw.RUnlock()
-	node, pathExists, completeWalk := t.lazyWalk(entry)
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
 	if !completeWalk {
 		return false
 	}
-	if pathExists {
+	if rest == "" && node.endOfKey {
 		return true
 	}
-	if entry.IsSelector() && node.hasChildren() {
-		//        / *
+	if entry.IsSelector() && (rest != "" || node.hasChildren() || node.hasSelector) {
+		//        * pending increment
 		//  node  - child
 		//        \ child
+		// or the prefix ends inside the label of node
 		return true
 	}
 	return false
 }
 
+// returns the longest key that is a prefix of "text", and its value
+// as returned by GetValue, "text" is converted and validated like a key
+func (t *Repository) LongestPrefixOf(text string) (prefix string, value int, ok bool) {
+	t.expireDue()
+	entry := string(*key.New(text, t.converter, t.validator))
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	node, depth := t.root, 0
+	counts := t.currentCounts()
+	for depth < len(entry) {
+		child, found := node.child(entry[depth])
+		if !found || !strings.HasPrefix(entry[depth:], child.label) {
+			break
+		}
+		node, depth = child, depth+len(child.label)
+		if node.endOfKey {
+			keyValue, selector := counts(node)
+			prefix, value, ok = entry[:depth], keyValue+selector, true
+		}
+	}
+	return prefix, value, ok
+}
+
 func (t *Repository) Size() int {
+	t.expireDue()
 	t.rw.RLock()
 	defer t.rw.RUnlock()
 	return t.size
@@ -229,11 +563,14 @@ func printTrie(node *trieNode, indent string, isLastChild bool) {
 		fmt.Print("┟━")
 		indent += "┃ "
 	}
-	fmt.Println(" "+string(node.symbol)+":", node.value)
+	if node.hasSelector {
+		fmt.Println(" "+node.label+":", node.value, string(key.SelectorChar)+":", node.selector)
+	} else {
+		fmt.Println(" "+node.label+":", node.value)
+	}
 
-	i := 0
-	for _, child := range node.children {
-		printTrie(child, indent, i == len(node.children)-1)
-		i++
+	children := node.sortedChildren()
+	for i, child := range children {
+		printTrie(child, indent, i == len(children)-1)
 	}
 }
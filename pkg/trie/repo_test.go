@@ -228,9 +228,9 @@ func TestRepository_Size_NoSideEffectAfterInc(t *testing.T) {
 func TestRepository_forceWalk_NonExistingKey(t *testing.T) {
 	repo := buildDefaultTrie()
 	pattern := "pattern"
-	node, patternExists := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := rune(pattern[len(pattern)-1])
-	actual := node.symbol
+	node, patternExists, _ := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
+	expected := pattern[len(pattern)-1]
+	actual := lastByte(node)
 	assert.Equal(t, expected, actual)
 	assert.False(t, patternExists)
 }
@@ -239,9 +239,9 @@ func TestRepository_forceWalk_NonExistingSelector(t *testing.T) {
 	repo := buildDefaultTrie()
 	pattern := "pattern/*"
 	_ = repo.Insert(pattern[0:len(pattern)-2], 1)
-	node, patternExists := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := rune(pattern[len(pattern)-2])
-	actual := node.symbol
+	node, patternExists, _ := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
+	expected := pattern[len(pattern)-2]
+	actual := lastByte(node)
 	assert.Equal(t, expected, actual)
 	assert.False(t, patternExists)
 }
@@ -250,9 +250,9 @@ func TestRepository_forceWalk_ExistingKey(t *testing.T) {
 	repo := buildDefaultTrie()
 	pattern := "pattern"
 	_ = repo.Insert(pattern, 100)
-	node, patternExists := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := rune(pattern[len(pattern)-1])
-	actual := node.symbol
+	node, patternExists, _ := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
+	expected := pattern[len(pattern)-1]
+	actual := lastByte(node)
 	assert.Equal(t, expected, actual)
 	assert.True(t, patternExists)
 }
@@ -262,9 +262,9 @@ func TestRepository_forceWalk_ExistingSelector(t *testing.T) {
 	pattern := "pattern/*"
 	_ = repo.Insert("pattern/", 10)
 	_ = repo.Inc(pattern)
-	node, patternExists := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := rune(pattern[len(pattern)-2])
-	actual := node.symbol
+	node, patternExists, _ := repo.forceWalk(key.New(pattern, repo.converter, repo.validator))
+	expected := pattern[len(pattern)-2]
+	actual := lastByte(node)
 	assert.Equal(t, expected, actual)
 	assert.True(t, patternExists)
 }
@@ -285,8 +285,8 @@ func TestRepository_lazyWalk_NonExistingSelector(t *testing.T) {
 	pattern := "pattern/*"
 	_ = repo.Insert(pattern[0:2], 1)
 	node, patternExists, completePath := repo.lazyWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := node.symbol
-	actual := rune(pattern[1])
+	expected := lastByte(node)
+	actual := pattern[1]
 	assert.Equal(t, expected, actual)
 	assert.False(t, patternExists)
 	assert.False(t, completePath)
@@ -297,8 +297,8 @@ func TestRepository_lazyWalk_ExistingKey(t *testing.T) {
 	pattern := "pattern"
 	_ = repo.Insert(pattern, 100)
 	node, patternExists, completePath := repo.lazyWalk(key.New(pattern, repo.converter, repo.validator))
-	expected := rune(pattern[len(pattern)-1])
-	actual := node.symbol
+	expected := pattern[len(pattern)-1]
+	actual := lastByte(node)
 	assert.Equal(t, expected, actual)
 	assert.True(t, patternExists)
 	assert.True(t, completePath)
@@ -310,8 +310,63 @@ func TestRepository_lazyWalk_ExistingSelector(t *testing.T) {
 	_ = repo.Insert(pattern[0:len(pattern)-1], 100)
 	node, patternExists, completePath := repo.lazyWalk(key.New(pattern, repo.converter, repo.validator))
 	expected := string(pattern[len(pattern)-2])
-	actual := string(node.symbol)
+	actual := string(lastByte(node))
 	assert.Equal(t, expected, actual)
 	assert.True(t, patternExists)
 	assert.True(t, completePath)
 }
+
+func lastByte(node *trieNode) byte {
+	return node.label[len(node.label)-1]
+}
+
+func TestRepository_forceWalk_SplitsLabel(t *testing.T) {
+	repo := buildTrieFromTokens(1, "pattern")
+	node, patternExists, _ := repo.forceWalk(key.New("pat*", repo.converter, repo.validator))
+	assert.Equal(t, "pat", node.label)
+	assert.Equal(t, "tern", childOf(node, 't').label)
+	assert.False(t, patternExists)
+	assert.Equal(t, 1, repo.GetValue("pattern"))
+}
+
+func TestRepository_lazyWalk_InsideLabel(t *testing.T) {
+	repo := buildTrieFromTokens(1, "pattern")
+	node, patternExists, completePath := repo.lazyWalk(key.New("pat*", repo.converter, repo.validator))
+	assert.Equal(t, "pattern", node.label)
+	assert.False(t, patternExists)
+	assert.True(t, completePath)
+}
+
+func TestRepository_Inc_SelectorInsideLabel(t *testing.T) {
+	repo := buildTrieFromTokens(1, "pattern", "pattern/a", "pattern/b")
+	assert.Error(t, repo.Inc("pat*"))
+	expected := map[string]int{"pat*": 1, "pattern": 1, "pattern/a": 1, "pattern/b": 1}
+	assert.Equal(t, expected, repo.GetMap("pa*"))
+	assert.Equal(t, 6, repo.GetValue("pa*"))
+	assert.Equal(t, 1, repo.GetValue("pat"))
+	assert.True(t, repo.Contains("patt*"))
+	assert.False(t, repo.Contains("pat"))
+	assert.Equal(t, 3, repo.Size())
+}
+
+func TestRepository_Insert_UnicodeKeys(t *testing.T) {
+	repo := buildTrieFromTokens(1, "é", "è", "éa")
+	_ = repo.Inc("é*")
+	expected := map[string]int{"é": 1, "é*": 1, "éa": 1}
+	assert.Equal(t, expected, repo.GetMap("é*"))
+	assert.Equal(t, 1, repo.GetValue("è"))
+	assert.Equal(t, 3, repo.Size())
+}
+
+func TestRepository_LongestPrefixOf(t *testing.T) {
+	repo := buildTrieFromTokens(1, "abc", "abcdef", "b")
+	_ = repo.Inc("abc*")
+	prefix, value, ok := repo.LongestPrefixOf("ABCDE")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", prefix)
+	assert.Equal(t, 2, value)
+	prefix, _, _ = repo.LongestPrefixOf("abcdefg")
+	assert.Equal(t, "abcdef", prefix)
+	_, _, ok = repo.LongestPrefixOf("ab")
+	assert.False(t, ok)
+}
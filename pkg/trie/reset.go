@@ -0,0 +1,121 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"time"
+)
+
+// zeros the values of the keys matching "pattern" and, for a selector,
+// clears the pending selector increments of its prefix and below it,
+// in a single pass under the lock, the keys are kept so Size
+// and Contains of raw keys are unchanged
+// a raw key also loses its own pending increment, e.g. "home/bin*",
+// so GetValue returns zero for it
+// returns the number of keys that matched "pattern"
+func (t *Repository) Reset(pattern string) int {
+	if len(t.observers) == 0 {
+		return t.reset(pattern)
+	}
+	start := time.Now()
+	matched := t.reset(pattern)
+	t.notify(OperationReset, pattern, start, matched, nil)
+	return matched
+}
+
+func (t *Repository) reset(pattern string) int {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.Lock()
+	events := make([]ChangeEvent, 0)
+	matched := 0
+	nodes := t.root.nodesAlong(entryPath(entry))
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if completeWalk && entry.IsSelector() {
+		// the prefix may end inside the label of node
+		path := entryPath(entry) + rest
+		if rest != "" {
+			nodes = append(t.root.nodesAlong(path[:len(path)-len(node.label)]), node)
+		}
+		matched, events = t.resetSubtree(node, []byte(path), events)
+		t.nodes -= compact(nodes)
+	} else if completeWalk && rest == "" && node.endOfKey {
+		matched = 1
+		events = t.resetKey(node, string(*entry), events)
+		events = t.resetSelector(node, string(*entry), events)
+	}
+	t.rw.Unlock()
+	for _, event := range events {
+		t.watchers.publish(event)
+	}
+	return matched
+}
+
+// zeros the value of the key held by "tn"
+// must hold the write lock
+func (t *Repository) resetKey(tn *trieNode, path string, events []ChangeEvent) []ChangeEvent {
+	if tn.value != 0 {
+		events = append(events, ChangeEvent{Key: path, Old: tn.value, Op: OperationReset})
+	}
+	tn.value = 0
+	if tn.decay != nil {
+		tn.decay.value = 0
+	}
+	if t.series != nil {
+		delete(t.series.keys, tn)
+	}
+	if t.capacity != nil {
+		t.capacity.touch(tn)
+	}
+	return events
+}
+
+// clears the pending selector increment of "tn", if any, the node is
+// left in the tree, "path" holds the bytes leading to "tn"
+// must hold the write lock
+func (t *Repository) resetSelector(tn *trieNode, path string, events []ChangeEvent) []ChangeEvent {
+	if !tn.hasSelector {
+		return events
+	}
+	if tn.selector != 0 {
+		events = append(events, ChangeEvent{Key: path + string(key.SelectorChar), Old: tn.selector, Op: OperationReset})
+	}
+	t.clearSelector(tn)
+	return events
+}
+
+// removes the pending selector increment of "tn", the node is left
+// in the tree
+// must hold the write lock
+func (t *Repository) clearSelector(tn *trieNode) {
+	tn.selector = 0
+	tn.hasSelector = false
+	if tn.decay != nil {
+		tn.decay.selector = 0
+	}
+	if t.series != nil {
+		delete(t.series.selectors, tn)
+	}
+}
+
+// zeros the values of the keys below "tn" and clears their pending selector
+// increments, the nodes left with no reason to exist are removed,
+// but for "tn" itself, "path" holds the bytes leading to "tn"
+// returns the number of keys below "tn"
+// must hold the write lock
+func (t *Repository) resetSubtree(tn *trieNode, path []byte, events []ChangeEvent) (int, []ChangeEvent) {
+	matched := 0
+	if tn.endOfKey {
+		matched++
+		events = t.resetKey(tn, string(path), events)
+	}
+	events = t.resetSelector(tn, string(path), events)
+	// the children change while they are visited
+	children := append([]*trieNode(nil), tn.sortedChildren()...)
+	for _, child := range children {
+		childMatched := 0
+		childMatched, events = t.resetSubtree(child, append(path, child.label...), events)
+		matched += childMatched
+		t.nodes -= compact([]*trieNode{tn, child})
+	}
+	return matched, events
+}
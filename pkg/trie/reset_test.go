@@ -0,0 +1,111 @@
+package trie
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func buildJobsTrie() *Repository {
+	repo := buildDefaultTrie()
+	_ = repo.Insert("jobs/build", 3)
+	_ = repo.Insert("jobs/build/arm", 2)
+	_ = repo.Insert("jobs/test", 5)
+	_ = repo.Insert("mail", 7)
+	_ = repo.Inc("jobs/*")
+	_ = repo.Inc("jobs/b*")
+	_ = repo.Inc("jobs/build/*")
+	_ = repo.Inc("m*")
+	return repo
+}
+
+func TestRepository_Reset_Selector(t *testing.T) {
+	repo := buildJobsTrie()
+	nodes := repo.Stats().Nodes
+	assert.Equal(t, 3, repo.Reset("jobs/*"))
+	assert.Equal(t, 4, repo.Size())
+	for _, k := range []string{"jobs/build", "jobs/build/arm", "jobs/test"} {
+		assert.True(t, repo.Contains(k))
+		assert.Equal(t, 0, repo.GetValue(k))
+	}
+	assert.Equal(t, 0, repo.GetValue("jobs/*"))
+	assert.Equal(t, map[string]int{"jobs/build": 0, "jobs/build/arm": 0, "jobs/test": 0}, repo.GetMap("jobs/*"))
+	// the nodes held by the selectors only are pruned
+	assert.Equal(t, nodes-2, repo.Stats().Nodes)
+	// the keys outside the prefix are kept as they are
+	assert.Equal(t, map[string]int{"mail": 7, "m*": 1}, repo.GetMap("m*"))
+
+	assert.NoError(t, repo.Inc("jobs/build"))
+	assert.Equal(t, 1, repo.GetValue("jobs/*"))
+}
+
+func TestRepository_Reset_InsideLabel(t *testing.T) {
+	repo := buildJobsTrie()
+	assert.Equal(t, 2, repo.Reset("jobs/bu*"))
+	assert.Equal(t, map[string]int{"jobs/*": 1, "jobs/b*": 1, "jobs/build": 0, "jobs/build/arm": 0, "jobs/test": 5}, repo.GetMap("jobs/*"))
+	assert.Equal(t, 0, repo.Reset("work/*"))
+	assert.Equal(t, 4, repo.Size())
+}
+
+func TestRepository_Reset_Raw(t *testing.T) {
+	repo := buildJobsTrie()
+	assert.Equal(t, 1, repo.Reset("jobs/build"))
+	assert.Equal(t, map[string]int{"jobs/*": 1, "jobs/b*": 1, "jobs/build": 0, "jobs/build/*": 1, "jobs/build/arm": 2, "jobs/test": 5}, repo.GetMap("jobs/*"))
+	assert.Equal(t, 0, repo.Reset("jobs/bui"))
+	assert.Equal(t, 0, repo.Reset("work"))
+
+	// the pending increment of the key itself is cleared
+	assert.NoError(t, repo.IncBy("jobs/test*", 2))
+	assert.Equal(t, 7, repo.GetValue("jobs/test"))
+	assert.Equal(t, 1, repo.Reset("jobs/test"))
+	assert.Equal(t, 0, repo.GetValue("jobs/test"))
+	assert.NotContains(t, repo.GetMap("jobs/*"), "jobs/test*")
+	assert.True(t, repo.Contains("jobs/test"))
+}
+
+func TestRepository_Reset_Observer(t *testing.T) {
+	observer := &recordingObserver{}
+	repo := buildJobsTrie().AddObserver(observer)
+	repo.Reset("jobs/*")
+	repo.Reset("work")
+	assert.Len(t, observer.operations, 2)
+	for idx, want := range []Operation{
+		{Name: OperationReset, Pattern: "jobs/*", ResultSize: 3},
+		{Name: OperationReset, Pattern: "work"},
+	} {
+		observer.operations[idx].Duration = 0
+		assert.Equal(t, want, observer.operations[idx])
+	}
+}
+
+func TestRepository_Reset_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildJobsTrie()
+	_ = repo.Insert("jobs/new", 0)
+	events := repo.Watch(ctx, "jobs/*")
+	repo.Reset("jobs/*")
+
+	// the keys that already were zero are not reported
+	expected := []ChangeEvent{
+		{Key: "jobs/*", Old: 1, Op: OperationReset},
+		{Key: "jobs/b*", Old: 1, Op: OperationReset},
+		{Key: "jobs/build", Old: 3, Op: OperationReset},
+		{Key: "jobs/build/*", Old: 1, Op: OperationReset},
+		{Key: "jobs/build/arm", Old: 2, Op: OperationReset},
+		{Key: "jobs/test", Old: 5, Op: OperationReset},
+	}
+	for _, want := range expected {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, want, event)
+	}
+	assertNoEvent(t, events)
+}
+
+func TestRepository_Reset_Capacity(t *testing.T) {
+	repo := buildJobsTrie().AddCapacity(Capacity{MaxKeys: 4, Policy: EvictLFU})
+	repo.Reset("mail")
+	assert.NoError(t, repo.Insert("work", 1))
+	assert.False(t, repo.Contains("mail"))
+	assert.Equal(t, 4, repo.Size())
+}
@@ -0,0 +1,306 @@
+package trie
+
+import (
+	"context"
+	"fmt"
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/clock"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// counts of a period of time, e.g. an hour
+// its repository must not be modified once the window is closed
+type Window struct {
+	Start      time.Time
+	End        time.Time
+	Repository *Repository
+}
+
+// receives the windows closed by a RolloverRepository
+type WindowSink interface {
+	SaveWindow(window Window) error
+}
+
+// sink saving every window to a snapshot file of its own in a directory,
+// named after the start of the window, e.g. "window-20210101T100000Z"
+type DirSink struct {
+	dir string
+}
+
+var _ WindowSink = (*DirSink)(nil)
+
+func NewDirSink(dir string) *DirSink {
+	return &DirSink{dir: dir}
+}
+
+func (s *DirSink) SaveWindow(window Window) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("window-%s", window.Start.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(s.dir, name)
+	temp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := window.Repository.Save(temp); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+type RolloverConfig struct {
+	// length of a window, windows start at multiples of it
+	// since the zero time, e.g. every hour on the hour
+	Period time.Duration
+	// number of closed windows that stay readable
+	Retained int
+	// builds the repository of every window, New if it is nil
+	New func() *Repository
+	// the keys of a closed window are carried into the next window,
+	// with a zero value, otherwise the next window starts empty
+	KeepKeys bool
+	// receives every closed window, may be nil, the empty windows
+	// of the periods without any call are saved as they are retained,
+	// so only the latest "Retained" of them
+	Sink WindowSink
+	// receives the errors of the sink for the windows closed
+	// on a change of period rather than by Rollover, may be nil
+	OnError func(err error)
+}
+
+// implements pkg.KeyValueRepository
+// every call goes to the repository of the current window, which is
+// swapped for an empty one at the end of every period, so the counts
+// of a window are the counts of its period
+// the windows are closed when the repository is used after the end
+// of their period, or by the scheduler started with StartScheduler
+type RolloverRepository struct {
+	rw     sync.RWMutex
+	config RolloverConfig
+	clock  clock.Clock
+	active Window
+	// closed windows, the latest first
+	previous []Window
+}
+
+var _ pkg.KeyValueRepository = (*RolloverRepository)(nil)
+
+// panics if the period of "config" is not positive
+func NewRolloverRepository(config RolloverConfig) *RolloverRepository {
+	if config.Period <= 0 {
+		panic("trie: the period of a rollover repository must be positive")
+	}
+	if config.New == nil {
+		config.New = New
+	}
+	r := &RolloverRepository{config: config, clock: clock.System()}
+	r.active = r.newWindow(r.clock.Now())
+	return r
+}
+
+// sets the clock telling when the periods end,
+// it must be set before the repository is shared
+func (r *RolloverRepository) AddClock(c clock.Clock) *RolloverRepository {
+	r.clock = c
+	r.active = r.newWindow(c.Now())
+	return r
+}
+
+// returns an empty window for the period of "at"
+func (r *RolloverRepository) newWindow(at time.Time) Window {
+	start := at.Truncate(r.config.Period)
+	return Window{Start: start, End: start.Add(r.config.Period), Repository: r.config.New()}
+}
+
+// closes the current window if its period is over, or at once if "force",
+// the windows of the periods without any call in between are kept empty
+// returns the closed windows, the oldest first, none if it is not over
+// must hold the write lock
+func (r *RolloverRepository) rollover(now time.Time, force bool) []Window {
+	if !force && now.Before(r.active.End) {
+		return nil
+	}
+	closed, next := r.active, r.newWindow(now)
+	if r.config.KeepKeys {
+		carryKeys(closed.Repository, next.Repository)
+	}
+	if force {
+		// the window ends early, the next one starts now
+		closed.End, next.Start = now, now
+	}
+	r.retain(closed)
+	windows := []Window{closed}
+	start := closed.End
+	// only the latest of the empty windows are retained
+	if skipped := next.Start.Add(-time.Duration(r.config.Retained) * r.config.Period); start.Before(skipped) {
+		start = skipped
+	}
+	for ; start.Before(next.Start); start = start.Add(r.config.Period) {
+		empty := Window{Start: start, End: start.Add(r.config.Period), Repository: r.config.New()}
+		r.retain(empty)
+		windows = append(windows, empty)
+	}
+	r.active = next
+	return windows
+}
+
+// inserts the keys of "from" into the empty repository "to", with a zero value
+func carryKeys(from, to *Repository) {
+	from.expireDue()
+	root, size := newRootNode(), 0
+	from.rw.RLock()
+	dfsVisitNodes(from.root, nil, func(tn *trieNode, path []byte) {
+		if restoreKey(root, string(path), 0) {
+			size++
+		}
+	})
+	from.rw.RUnlock()
+	to.rw.Lock()
+	events := to.replaceRoot(root, size, nil, OperationLoad)
+	to.rw.Unlock()
+	to.watchers.publishAll(events)
+	to.reportEvictions()
+}
+
+// keeps "window" as the latest closed window
+// must hold the write lock
+func (r *RolloverRepository) retain(window Window) {
+	r.previous = append([]Window{window}, r.previous...)
+	if len(r.previous) > r.config.Retained {
+		r.previous = r.previous[:r.config.Retained]
+	}
+}
+
+// saves every window in "windows", a window the sink fails to save
+// does not stop the next ones, "failed" receives its error
+func (r *RolloverRepository) save(windows []Window, failed func(err error)) {
+	if r.config.Sink == nil {
+		return
+	}
+	for _, window := range windows {
+		if err := r.config.Sink.SaveWindow(window); err != nil {
+			failed(err)
+		}
+	}
+}
+
+// closes the current window if its period is over at "now"
+func (r *RolloverRepository) closeDue(now time.Time) {
+	r.rw.Lock()
+	closed := r.rollover(now, false)
+	r.rw.Unlock()
+	r.save(closed, func(err error) {
+		if r.config.OnError != nil {
+			r.config.OnError(err)
+		}
+	})
+}
+
+// calls "use" with the repository of the current window, closing it first
+// if its period is over, the window is not closed while "use" runs,
+// so no call is counted in a window once it is closed
+func (r *RolloverRepository) use(use func(repo *Repository)) {
+	for {
+		now := r.clock.Now()
+		r.rw.RLock()
+		if now.Before(r.active.End) {
+			defer r.rw.RUnlock()
+			use(r.active.Repository)
+			return
+		}
+		r.rw.RUnlock()
+		r.closeDue(now)
+	}
+}
+
+// closes the current window now, before the end of its period,
+// the next window starts now and ends with the period
+// returns the first error of the sink saving the closed windows
+func (r *RolloverRepository) Rollover() error {
+	r.rw.Lock()
+	closed := r.rollover(r.clock.Now(), true)
+	r.rw.Unlock()
+	var first error
+	r.save(closed, func(err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	return first
+}
+
+// returns the current window, its repository is the one calls go to
+func (r *RolloverRepository) Current() Window {
+	r.closeDue(r.clock.Now())
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	return r.active
+}
+
+// returns the closed window "n" windows back, 1 being the latest one,
+// and false if it is not retained
+func (r *RolloverRepository) Previous(n int) (Window, bool) {
+	r.closeDue(r.clock.Now())
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+	if n < 1 || n > len(r.previous) {
+		return Window{}, false
+	}
+	return r.previous[n-1], true
+}
+
+// closes the windows at the end of their periods until "ctx" is done
+// rather than when the repository is used next, so the sink receives
+// the windows as soon as they are closed
+func (r *RolloverRepository) StartScheduler(ctx context.Context) {
+	go func() {
+		for {
+			wait := r.Current().End.Sub(r.clock.Now())
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+func (r *RolloverRepository) Insert(pattern string, value int) (err error) {
+	r.use(func(repo *Repository) { err = repo.Insert(pattern, value) })
+	return err
+}
+
+func (r *RolloverRepository) GetMap(pattern string) (results map[string]int) {
+	r.use(func(repo *Repository) { results = repo.GetMap(pattern) })
+	return results
+}
+
+func (r *RolloverRepository) GetValue(pattern string) (value int) {
+	r.use(func(repo *Repository) { value = repo.GetValue(pattern) })
+	return value
+}
+
+func (r *RolloverRepository) Inc(pattern string) (err error) {
+	r.use(func(repo *Repository) { err = repo.Inc(pattern) })
+	return err
+}
+
+func (r *RolloverRepository) Contains(pattern string) (found bool) {
+	r.use(func(repo *Repository) { found = repo.Contains(pattern) })
+	return found
+}
+
+func (r *RolloverRepository) Size() (size int) {
+	r.use(func(repo *Repository) { size = repo.Size() })
+	return size
+}
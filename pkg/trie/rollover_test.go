@@ -0,0 +1,155 @@
+package trie
+
+import (
+	"errors"
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type windowRecorder struct {
+	mutex   sync.Mutex
+	windows []Window
+	err     error
+}
+
+func (wr *windowRecorder) SaveWindow(window Window) error {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	wr.windows = append(wr.windows, window)
+	return wr.err
+}
+
+func buildRollover(config RolloverConfig) (*RolloverRepository, *clock.Manual) {
+	manual := clock.NewManual(time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC))
+	return NewRolloverRepository(config).AddClock(manual), manual
+}
+
+func TestRolloverRepository_Rolls(t *testing.T) {
+	sink := &windowRecorder{}
+	repo, manual := buildRollover(RolloverConfig{Period: time.Hour, Retained: 2, Sink: sink})
+	assert.NoError(t, repo.Insert("home/a", 1))
+	assert.NoError(t, repo.Inc("home/a"))
+	assert.Equal(t, time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC), repo.Current().Start)
+	_, ok := repo.Previous(1)
+	assert.False(t, ok)
+
+	manual.Advance(30 * time.Minute)
+	assert.Equal(t, 0, repo.Size())
+	assert.NoError(t, repo.Insert("home/b", 5))
+	previous, ok := repo.Previous(1)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 1, 1, 11, 0, 0, 0, time.UTC), previous.End)
+	assert.Equal(t, map[string]int{"home/a": 2}, previous.Repository.GetMap("*"))
+	assert.Equal(t, map[string]int{"home/b": 5}, repo.GetMap("*"))
+	assert.Len(t, sink.windows, 1)
+	assert.Equal(t, previous, sink.windows[0])
+}
+
+func TestNewRolloverRepository_InvalidPeriod(t *testing.T) {
+	assert.Panics(t, func() { NewRolloverRepository(RolloverConfig{Retained: 2}) })
+	assert.Panics(t, func() { NewRolloverRepository(RolloverConfig{Period: -time.Hour}) })
+}
+
+func TestRolloverRepository_SkippedPeriods(t *testing.T) {
+	sink := &windowRecorder{}
+	repo, manual := buildRollover(RolloverConfig{Period: time.Hour, Retained: 3, Sink: sink})
+	_ = repo.Insert("a", 1)
+	manual.Advance(10 * time.Hour)
+	assert.Equal(t, 0, repo.GetValue("a"))
+	// the periods without calls are retained empty, only the latest ones
+	for n, start := range []int{19, 18, 17} {
+		window, ok := repo.Previous(n + 1)
+		assert.True(t, ok)
+		assert.Equal(t, start, window.Start.Hour())
+		assert.Equal(t, 0, window.Repository.Size())
+	}
+	_, ok := repo.Previous(4)
+	assert.False(t, ok)
+	// the retained empty windows are saved too, the oldest first
+	assert.Len(t, sink.windows, 4)
+	assert.Equal(t, 1, sink.windows[0].Repository.GetValue("a"))
+	for idx, start := range []int{17, 18, 19} {
+		assert.Equal(t, start, sink.windows[idx+1].Start.Hour())
+		assert.Equal(t, 0, sink.windows[idx+1].Repository.Size())
+	}
+}
+
+func TestRolloverRepository_Rollover(t *testing.T) {
+	sink := &windowRecorder{err: errors.New("disk full")}
+	var reported []error
+	repo, manual := buildRollover(RolloverConfig{
+		Period:   time.Hour,
+		Retained: 1,
+		Sink:     sink,
+		KeepKeys: true,
+		OnError:  func(err error) { reported = append(reported, err) },
+	})
+	_ = repo.Insert("a", 3)
+	_ = repo.Inc("a*")
+	manual.Advance(15 * time.Minute)
+	assert.EqualError(t, repo.Rollover(), "disk full")
+	current := repo.Current()
+	assert.Equal(t, manual.Now(), current.Start)
+	assert.Equal(t, time.Date(2021, 1, 1, 11, 0, 0, 0, time.UTC), current.End)
+	// the keys are carried over, without their values
+	assert.Equal(t, map[string]int{"a": 0}, repo.GetMap("*"))
+	assert.NoError(t, repo.Inc("a"))
+	previous, _ := repo.Previous(1)
+	assert.Equal(t, manual.Now(), previous.End)
+	assert.Equal(t, 4, previous.Repository.GetValue("a"))
+	assert.Empty(t, reported)
+
+	manual.Advance(time.Hour)
+	assert.Equal(t, 0, repo.GetValue("a"))
+	assert.Len(t, reported, 1)
+	previous, _ = repo.Previous(1)
+	assert.Equal(t, 1, previous.Repository.GetValue("a"))
+}
+
+func TestRolloverRepository_DirSink(t *testing.T) {
+	dir := t.TempDir()
+	repo, manual := buildRollover(RolloverConfig{Period: time.Hour, Sink: NewDirSink(dir)})
+	_ = repo.Insert("a", 7)
+	manual.Advance(time.Hour)
+	repo.Current()
+
+	file, err := os.Open(filepath.Join(dir, "window-20210101T100000Z"))
+	assert.NoError(t, err)
+	defer file.Close()
+	loaded := New()
+	assert.NoError(t, loaded.Load(file))
+	assert.Equal(t, map[string]int{"a": 7}, loaded.GetMap("*"))
+}
+
+func TestRolloverRepository_Concurrent(t *testing.T) {
+	sink := &windowRecorder{}
+	repo, manual := buildRollover(RolloverConfig{Period: time.Minute, Retained: 100, Sink: sink, KeepKeys: true})
+	_ = repo.Insert("a", 0)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				_ = repo.Inc("a")
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		manual.Advance(time.Minute)
+		repo.Current()
+	}
+	wg.Wait()
+	// every increment is counted in exactly one window
+	total := repo.GetValue("a")
+	for n := 1; n <= 10; n++ {
+		window, _ := repo.Previous(n)
+		total += window.Repository.GetValue("a")
+	}
+	assert.Equal(t, 1000, total)
+}
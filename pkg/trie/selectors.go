@@ -0,0 +1,73 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"time"
+)
+
+// returns the pending selector increments made with Inc, IncBy or IncAt,
+// by selector, e.g. "home/bin/*", with the sum of their deltas
+// as GetMap returns them, i.e. decayed up to now on a decaying repository
+// a selector pattern returns the pending increments of its prefix
+// and of the longer prefixes, a raw pattern the one of itself as a prefix
+func (t *Repository) ListSelectorIncrements(pattern string) map[string]int {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	results := make(map[string]int)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	node, rest, completeWalk := t.root.lazyWalk(entryPath(entry))
+	if !completeWalk {
+		return results
+	}
+	counts := t.currentCounts()
+	if entry.IsRaw() {
+		if rest == "" && node.hasSelector {
+			_, results[string(*entry)+string(key.SelectorChar)] = counts(node)
+		}
+		return results
+	}
+	// the prefix may end inside the label of node
+	dfsVisitCounts(node, []byte(entryPath(entry)+rest), counts, func(path []byte, count int, selector bool) {
+		if selector {
+			results[string(path)] = count
+		}
+	})
+	return results
+}
+
+// removes the pending increment of the selector "pattern", e.g. "home/bin/*",
+// undoing the increments it made to the keys of its prefix at once,
+// the increments of the other selectors are kept
+// returns the delta it held, as ListSelectorIncrements does, or an error if "pattern" is not a selector
+// or it has no pending increment
+func (t *Repository) ClearSelectorIncrement(pattern string) (int, error) {
+	if len(t.observers) == 0 {
+		return t.clearSelectorIncrement(pattern)
+	}
+	start := time.Now()
+	delta, err := t.clearSelectorIncrement(pattern)
+	t.notify(OperationClear, pattern, start, changedKeys(err), err)
+	return delta, err
+}
+
+func (t *Repository) clearSelectorIncrement(pattern string) (int, error) {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	if !entry.IsSelector() {
+		return 0, NewErrNotSelector(string(*entry))
+	}
+	t.rw.Lock()
+	nodes := t.root.nodesAlong(entryPath(entry))
+	if nodes == nil || !nodes[len(nodes)-1].hasSelector {
+		t.rw.Unlock()
+		return 0, key.NewErrKeyNotFound(*entry)
+	}
+	node := nodes[len(nodes)-1]
+	_, delta := t.currentCounts()(node)
+	t.clearSelector(node)
+	t.nodes -= compact(nodes)
+	t.rw.Unlock()
+	t.watchers.publish(ChangeEvent{Key: string(*entry), Old: delta, Op: OperationClear})
+	return delta, nil
+}
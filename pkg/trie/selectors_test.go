@@ -0,0 +1,79 @@
+package trie
+
+import (
+	"context"
+	"github.com/intenvy/memoir/pkg/key"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRepository_ListSelectorIncrements(t *testing.T) {
+	repo := buildJobsTrie()
+	_ = repo.Inc("jobs/*")
+	expected := map[string]int{"jobs/*": 2, "jobs/b*": 1, "jobs/build/*": 1}
+	assert.Equal(t, expected, repo.ListSelectorIncrements("jobs/*"))
+	assert.Equal(t, map[string]int{"jobs/build/*": 1}, repo.ListSelectorIncrements("jobs/bu*"))
+	assert.Equal(t, map[string]int{"jobs/*": 2}, repo.ListSelectorIncrements("jobs/"))
+	assert.Equal(t, map[string]int{}, repo.ListSelectorIncrements("jobs/build"))
+	assert.Equal(t, map[string]int{}, repo.ListSelectorIncrements("work/*"))
+	assert.Len(t, repo.ListSelectorIncrements("*"), 4)
+}
+
+func TestRepository_ClearSelectorIncrement(t *testing.T) {
+	repo := buildJobsTrie()
+	nodes := repo.Stats().Nodes
+	assert.Equal(t, 16, repo.GetValue("jobs/*"))
+	delta, err := repo.ClearSelectorIncrement("jobs/b*")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delta)
+	// the node held by the selector only is pruned
+	assert.Equal(t, nodes-1, repo.Stats().Nodes)
+	// the keys of the prefix are no longer incremented by it
+	assert.Equal(t, 14, repo.GetValue("jobs/*"))
+	assert.Equal(t, map[string]int{"jobs/*": 1, "jobs/build/*": 1}, repo.ListSelectorIncrements("jobs/*"))
+
+	_, err = repo.ClearSelectorIncrement("jobs/b*")
+	assert.IsType(t, &key.ErrKeyNotFound{}, err)
+	_, err = repo.ClearSelectorIncrement("jobs/build")
+	assert.IsType(t, &ErrNotSelector{}, err)
+	assert.Equal(t, 4, repo.Size())
+}
+
+func TestRepository_ClearSelectorIncrement_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildJobsTrie()
+	events := repo.Watch(ctx, "jobs/*")
+	_, _ = repo.ClearSelectorIncrement("jobs/build/*")
+
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "jobs/build/*", Old: 1, Op: OperationClear}, event)
+	assertNoEvent(t, events)
+}
+
+func TestRepository_ListSelectorIncrements_Decay(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddDecay(time.Minute)
+	_ = repo.Insert("a/x", 0)
+	// applied although "a/" is not a key
+	_ = repo.IncBy("a/*", 8)
+	manual.Advance(2 * time.Minute)
+	// as GetMap returns them
+	assert.Equal(t, map[string]int{"a/*": 2}, repo.ListSelectorIncrements("a/*"))
+	assert.Equal(t, 2, repo.GetMap("a/*")["a/*"])
+	delta, err := repo.ClearSelectorIncrement("a/*")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delta)
+}
+
+func TestRepository_ClearSelectorIncrement_Observer(t *testing.T) {
+	observer := &recordingObserver{}
+	repo := buildJobsTrie().AddObserver(observer)
+	_, _ = repo.ClearSelectorIncrement("jobs/*")
+	_, _ = repo.ClearSelectorIncrement("jobs/*")
+	assert.Len(t, observer.operations, 2)
+	assert.Equal(t, OperationClear, observer.operations[0].Name)
+	assert.Equal(t, 1, observer.operations[0].ResultSize)
+	assert.IsType(t, &key.ErrKeyNotFound{}, observer.operations[1].Err)
+}
@@ -0,0 +1,187 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/key"
+	"math"
+	"time"
+)
+
+// intervals of the counters of a repository in time series mode
+type TimeSeries struct {
+	// length of an interval, every interval has its own counter
+	Resolution time.Duration
+	// how long the counters of an interval are kept,
+	// rounded up to a multiple of the resolution
+	Retention time.Duration
+}
+
+// counters of the last intervals of a key, or of a pending selector
+// an interval is stored in the slot of its number modulo the number of slots
+type counterRing struct {
+	counts []int
+	// number of the interval of every slot, since the unix epoch
+	intervals []int64
+}
+
+// returns the sum of the counters of the intervals from "from" to "to"
+// a nil ring has no counter
+func (cr *counterRing) sum(from, to int64) int {
+	if cr == nil {
+		return 0
+	}
+	total := 0
+	for idx, interval := range cr.intervals {
+		if interval >= from && interval <= to {
+			total += cr.counts[idx]
+		}
+	}
+	return total
+}
+
+type seriesIndex struct {
+	TimeSeries
+	slots     int64
+	keys      map[*trieNode]*counterRing
+	selectors map[*trieNode]*counterRing
+}
+
+func newSeriesIndex(config TimeSeries) *seriesIndex {
+	si := &seriesIndex{TimeSeries: config}
+	si.slots = int64((config.Retention + config.Resolution - 1) / config.Resolution)
+	if si.slots < 1 {
+		si.slots = 1
+	}
+	si.reset()
+	return si
+}
+
+func (si *seriesIndex) reset() {
+	si.keys = make(map[*trieNode]*counterRing)
+	si.selectors = make(map[*trieNode]*counterRing)
+}
+
+// returns the number of the interval of "at"
+func (si *seriesIndex) interval(at time.Time) int64 {
+	nanos := at.UnixNano()
+	interval := nanos / int64(si.Resolution)
+	if nanos < 0 && nanos%int64(si.Resolution) != 0 {
+		interval--
+	}
+	return interval
+}
+
+// adds "delta" to the counter of "node" in "rings" for the interval of "at"
+// an interval older than the one stored in its slot is not kept anymore,
+// and an interval later than the one of "now" is not counted, since
+// it would take the slot of an interval that is still retained
+func (si *seriesIndex) record(rings map[*trieNode]*counterRing, node *trieNode, delta int, at, now time.Time) {
+	interval := si.interval(at)
+	if interval > si.interval(now) {
+		return
+	}
+	ring, ok := rings[node]
+	if !ok {
+		ring = &counterRing{counts: make([]int, si.slots), intervals: make([]int64, si.slots)}
+		for idx := range ring.intervals {
+			ring.intervals[idx] = math.MinInt64
+		}
+		rings[node] = ring
+	}
+	slot := (interval%si.slots + si.slots) % si.slots
+	switch {
+	case ring.intervals[slot] == interval:
+		ring.counts[slot] += delta
+	case ring.intervals[slot] < interval:
+		ring.intervals[slot] = interval
+		ring.counts[slot] = delta
+	}
+}
+
+// returns the numbers of the first and the last intervals of the range
+// "from" to "to", bounded by the retention of the counters
+func (t *Repository) seriesRange(from, to time.Time) (int64, int64) {
+	first, last := t.series.interval(from), t.series.interval(to.Add(-1))
+	if oldest := t.series.interval(t.clock.Now()) - t.series.slots + 1; first < oldest {
+		first = oldest
+	}
+	return first, last
+}
+
+// counts "delta" in the interval of "at" for "node" in "rings",
+// a zero "at" being the time of the clock
+// must hold the write lock
+func (t *Repository) recordSeries(rings map[*trieNode]*counterRing, node *trieNode, delta int, at time.Time) {
+	now := t.clock.Now()
+	if at.IsZero() {
+		at = now
+	}
+	t.series.record(rings, node, delta, at, now)
+}
+
+// turns the repository into a time series, where every increment
+// is also counted in the interval it happens in, so the increments
+// of a time range can be read with GetValueRange and GetMapRange
+// the values of the keys are kept as they are, and the counters
+// are neither saved with the snapshots nor exported
+// panics if the resolution of "config" is not positive
+func (t *Repository) AddTimeSeries(config TimeSeries) *Repository {
+	if config.Resolution <= 0 {
+		panic("trie: the resolution of a time series must be positive")
+	}
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	t.series = newSeriesIndex(config)
+	return t
+}
+
+// same as Inc, the increment is counted in the interval of "at"
+// rather than in the current one, unless it is older than the retention
+// or later than the current interval
+func (t *Repository) IncAt(pattern string, at time.Time) error {
+	if len(t.observers) == 0 {
+		return t.inc(pattern, 1, at, OperationIncAt)
+	}
+	start := time.Now()
+	err := t.inc(pattern, 1, at, OperationIncAt)
+	t.notify(OperationIncAt, pattern, start, changedKeys(err), err)
+	return err
+}
+
+// returns the counts of the nodes in the intervals from "from" to "to"
+func (si *seriesIndex) countsIn(from, to int64) nodeCounts {
+	return func(tn *trieNode) (int, int) {
+		return si.keys[tn].sum(from, to), si.selectors[tn].sum(from, to)
+	}
+}
+
+// same as GetValue, counting only the increments of the intervals
+// overlapping the range from "from" up to "to" excluded
+// returns zero if the repository is not a time series
+func (t *Repository) GetValueRange(pattern string, from, to time.Time) int {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	if t.series == nil {
+		return 0
+	}
+	first, last := t.seriesRange(from, to)
+	value, _ := t.valueOf(entry, t.series.countsIn(first, last))
+	return value
+}
+
+// same as GetMap, counting only the increments of the intervals
+// overlapping the range from "from" up to "to" excluded
+// returns an empty map if the repository is not a time series
+func (t *Repository) GetMapRange(pattern string, from, to time.Time) map[string]int {
+	t.expireDue()
+	entry := key.New(pattern, t.converter, t.validator)
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	if t.series == nil {
+		return make(map[string]int)
+	}
+	first, last := t.seriesRange(from, to)
+	results, _ := t.mapOf(entry, t.series.countsIn(first, last))
+	return results
+}
@@ -0,0 +1,124 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func buildSeriesTrie() (*Repository, *clock.Manual) {
+	repo, manual := buildClockTrie()
+	repo.AddTimeSeries(TimeSeries{Resolution: time.Minute, Retention: time.Hour})
+	for _, k := range []string{"home/", "home/a", "home/b", "var/log"} {
+		_ = repo.Insert(k, 0)
+	}
+	// ten o'clock
+	manual.Advance(10 * time.Hour)
+	return repo, manual
+}
+
+func TestRepository_GetValueRange(t *testing.T) {
+	repo, manual := buildSeriesTrie()
+	ten := manual.Now()
+	// the increments must not be later than the clock
+	manual.Advance(30 * time.Minute)
+	assert.NoError(t, repo.IncAt("home/a", ten))
+	assert.NoError(t, repo.IncAt("home/a", ten.Add(5*time.Minute)))
+	assert.NoError(t, repo.IncAt("home/b", ten.Add(14*time.Minute+59*time.Second)))
+	assert.NoError(t, repo.IncAt("home/b", ten.Add(15*time.Minute)))
+	assert.NoError(t, repo.IncAt("var/log", ten.Add(time.Minute)))
+
+	quarter := ten.Add(15 * time.Minute)
+	assert.Equal(t, 3, repo.GetValueRange("home/*", ten, quarter))
+	assert.Equal(t, 4, repo.GetValueRange("home/*", ten, quarter.Add(time.Second)))
+	assert.Equal(t, 1, repo.GetValueRange("home/a", ten.Add(time.Minute), quarter))
+	assert.Equal(t, 3, repo.GetValueRange("*", ten, ten.Add(10*time.Minute)))
+	assert.Equal(t, 0, repo.GetValueRange("home/c", ten, quarter))
+	// the values are still the totals
+	assert.Equal(t, 2, repo.GetValue("home/a"))
+}
+
+func TestRepository_GetValueRange_Selectors(t *testing.T) {
+	repo, manual := buildSeriesTrie()
+	ten := manual.Now()
+	// the increments must not be later than the clock
+	manual.Advance(30 * time.Minute)
+	assert.NoError(t, repo.IncAt("home/*", ten))
+	assert.NoError(t, repo.IncAt("home/a*", ten.Add(time.Minute)))
+	hour := ten.Add(time.Hour)
+	assert.Equal(t, 4, repo.GetValueRange("home/*", ten, hour))
+	// like GetValue, a key only carries its own pending increment
+	assert.Equal(t, 0, repo.GetValueRange("home/b", ten, hour))
+	assert.Equal(t, 1, repo.GetValueRange("home/a", ten, hour))
+	assert.Equal(t, 1, repo.GetValueRange("home/a*", ten, hour))
+	assert.Equal(t, 1, repo.GetValueRange("home/*", ten.Add(time.Minute), hour))
+	assert.Equal(t, map[string]int{
+		"home/":   0,
+		"home/*":  1,
+		"home/a":  0,
+		"home/a*": 1,
+		"home/b":  0,
+	}, repo.GetMapRange("home/*", ten, hour))
+}
+
+func TestRepository_GetMapRange(t *testing.T) {
+	repo, manual := buildSeriesTrie()
+	ten := manual.Now()
+	// Inc counts at the time of the clock
+	assert.NoError(t, repo.Inc("home/a"))
+	assert.NoError(t, repo.IncBy("var/log", 5))
+	assert.NoError(t, repo.IncAt("var/log", ten.Add(-time.Minute)))
+	assert.Equal(t, map[string]int{"home/": 0, "home/a": 1, "home/b": 0}, repo.GetMapRange("home/*", ten, ten.Add(time.Minute)))
+	assert.Equal(t, map[string]int{"var/log": 6}, repo.GetMapRange("var/log", ten.Add(-time.Hour), ten.Add(time.Hour)))
+	assert.Equal(t, map[string]int{"var/log": 1}, repo.GetMapRange("var/*", ten.Add(-time.Minute), ten))
+}
+
+func TestRepository_TimeSeries_Retention(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddTimeSeries(TimeSeries{Resolution: time.Minute, Retention: 10 * time.Minute})
+	_ = repo.Insert("a", 0)
+	start := manual.Now()
+	assert.NoError(t, repo.Inc("a"))
+	manual.Advance(9 * time.Minute)
+	assert.NoError(t, repo.Inc("a"))
+	assert.Equal(t, 2, repo.GetValueRange("a", start, manual.Now().Add(time.Minute)))
+	// the first minute is beyond the retention
+	manual.Advance(time.Minute)
+	assert.Equal(t, 1, repo.GetValueRange("a", start, manual.Now()))
+	// its slot is reused
+	assert.NoError(t, repo.Inc("a"))
+	assert.Equal(t, 2, repo.GetValueRange("a", start, manual.Now().Add(time.Minute)))
+	// increments older than the retention are not counted
+	assert.NoError(t, repo.IncAt("a", start))
+	assert.Equal(t, 2, repo.GetValueRange("a", start, manual.Now().Add(time.Minute)))
+	assert.Equal(t, 4, repo.GetValue("a"))
+}
+
+func TestRepository_IncAt_Future(t *testing.T) {
+	repo, manual := buildClockTrie()
+	repo.AddTimeSeries(TimeSeries{Resolution: time.Minute, Retention: 10 * time.Minute})
+	_ = repo.Insert("a", 0)
+	now := manual.Now()
+	assert.NoError(t, repo.Inc("a"))
+	// same slot as the current interval, which is still retained
+	assert.NoError(t, repo.IncAt("a", now.Add(10*time.Minute)))
+	assert.Equal(t, 1, repo.GetValueRange("a", now, now.Add(time.Minute)))
+	assert.Equal(t, 2, repo.GetValue("a"))
+	manual.Advance(10 * time.Minute)
+	assert.Equal(t, 0, repo.GetValueRange("a", now, manual.Now().Add(time.Minute)))
+}
+
+func TestRepository_AddTimeSeries_InvalidResolution(t *testing.T) {
+	assert.Panics(t, func() { buildDefaultTrie().AddTimeSeries(TimeSeries{}) })
+}
+
+func TestRepository_TimeSeries_Disabled(t *testing.T) {
+	repo := buildDefaultTrie()
+	_ = repo.Insert("a", 0)
+	now := time.Now()
+	assert.NoError(t, repo.IncAt("a", now))
+	assert.Equal(t, 1, repo.GetValue("a"))
+	assert.Equal(t, 0, repo.GetValueRange("a", now.Add(-time.Hour), now.Add(time.Hour)))
+	assert.Empty(t, repo.GetMapRange("*", now.Add(-time.Hour), now.Add(time.Hour)))
+}
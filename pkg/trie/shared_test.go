@@ -0,0 +1,19 @@
+package trie
+
+import (
+	"github.com/intenvy/memoir/pkg"
+	"github.com/intenvy/memoir/pkg/repotest"
+	"testing"
+)
+
+func newSharedRepository() pkg.KeyValueRepository {
+	return New()
+}
+
+func TestRepository_Shared(t *testing.T) {
+	repotest.Run(t, newSharedRepository)
+}
+
+func BenchmarkRepository_Shared(b *testing.B) {
+	repotest.Benchmark(b, newSharedRepository)
+}
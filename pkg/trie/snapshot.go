@@ -0,0 +1,213 @@
+package trie
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// binary snapshot layout, all integers are varints:
+//
+//	magic "MEMR" | version | number of entries
+//	entry: shared prefix length | suffix length | suffix | value | deadline
+//	crc32 (IEEE, little endian) of everything before it
+//
+// entries are written in lexicographic order and every key
+// only stores the bytes that differ from the previous key
+// pending selector increments are stored as keys ending with the selector
+// the deadline of a key with a time to live is stored in unix nanoseconds,
+// zero for the other keys, version 1 snapshots have no deadlines
+const (
+	snapshotMagic   = "MEMR"
+	snapshotVersion = 2
+	// upper bound of a single key suffix, protects against corrupt lengths
+	maxSnapshotKeySize = 1 << 24
+)
+
+// writes a snapshot of the repository to "w"
+func (t *Repository) Save(w io.Writer) error {
+	t.expireDue()
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	return writeSnapshot(w, t.root, t.currentCounts(), t.expiries.deadlines())
+}
+
+// name of the operation reported to watchers for the keys
+// changed by Load or by decoding a repository
+const OperationLoad = "Load"
+
+// replaces the content of the repository with the snapshot read from "r"
+// the repository is left untouched if the snapshot cannot be read
+func (t *Repository) Load(r io.Reader) error {
+	root, size, deadlines, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	t.rw.Lock()
+	events := t.replaceRoot(root, size, deadlines, OperationLoad)
+	t.rw.Unlock()
+	t.watchers.publishAll(events)
+	t.reportEvictions()
+	return nil
+}
+
+type snapshotWriter struct {
+	w       *bufio.Writer
+	crc     hash.Hash32
+	scratch [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	_, _ = sw.crc.Write(p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeUvarint(v uint64) {
+	sw.write(sw.scratch[:binary.PutUvarint(sw.scratch[:], v)])
+}
+
+func (sw *snapshotWriter) writeVarint(v int64) {
+	sw.write(sw.scratch[:binary.PutVarint(sw.scratch[:], v)])
+}
+
+// "deadlines" holds the deadlines of the keys with a time to live
+func writeSnapshot(w io.Writer, root *trieNode, counts nodeCounts, deadlines map[string]time.Time) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+	count := 0
+	dfsVisitKeys(root, nil, counts, func(path []byte, value int) {
+		count++
+	})
+	sw.write([]byte(snapshotMagic))
+	sw.writeUvarint(snapshotVersion)
+	sw.writeUvarint(uint64(count))
+
+	previous := ""
+	dfsVisitKeys(root, nil, counts, func(path []byte, value int) {
+		current := string(path)
+		shared := sharedPrefixLength(previous, current)
+		sw.writeUvarint(uint64(shared))
+		sw.writeUvarint(uint64(len(current) - shared))
+		sw.write([]byte(current[shared:]))
+		sw.writeVarint(int64(value))
+		deadline := int64(0)
+		if at, ok := deadlines[current]; ok {
+			deadline = at.UnixNano()
+		}
+		sw.writeVarint(deadline)
+		previous = current
+	})
+	if sw.err != nil {
+		return sw.err
+	}
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], sw.crc.Sum32())
+	if _, err := sw.w.Write(trailer[:]); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+func sharedPrefixLength(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		_, _ = sr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	_, _ = sr.crc.Write(p[:n])
+	return n, err
+}
+
+// returns the tree, its number of keys and the deadlines of its keys
+func readSnapshot(r io.Reader) (*trieNode, int, map[string]time.Time, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, 0, nil, NewErrCorruptSnapshot("missing magic header")
+	}
+	version, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, 0, nil, NewErrCorruptSnapshot("missing version")
+	}
+	if version != 1 && version != snapshotVersion {
+		return nil, 0, nil, NewErrCorruptSnapshot("unsupported version")
+	}
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, 0, nil, NewErrCorruptSnapshot("missing number of entries")
+	}
+
+	var (
+		root      = newRootNode()
+		size      = 0
+		previous  = make([]byte, 0)
+		deadlines = make(map[string]time.Time)
+	)
+	for i := uint64(0); i < count; i++ {
+		shared, err := binary.ReadUvarint(sr)
+		if err != nil || shared > uint64(len(previous)) {
+			return nil, 0, nil, NewErrCorruptSnapshot("invalid shared prefix length")
+		}
+		suffixSize, err := binary.ReadUvarint(sr)
+		if err != nil || suffixSize > maxSnapshotKeySize {
+			return nil, 0, nil, NewErrCorruptSnapshot("invalid suffix length")
+		}
+		current := append(previous[:shared], make([]byte, suffixSize)...)
+		if _, err := io.ReadFull(sr, current[shared:]); err != nil {
+			return nil, 0, nil, NewErrCorruptSnapshot("truncated key")
+		}
+		value, err := binary.ReadVarint(sr)
+		if err != nil {
+			return nil, 0, nil, NewErrCorruptSnapshot("missing value")
+		}
+		if version > 1 {
+			deadline, err := binary.ReadVarint(sr)
+			if err != nil {
+				return nil, 0, nil, NewErrCorruptSnapshot("missing deadline")
+			}
+			if deadline != 0 {
+				deadlines[string(current)] = time.Unix(0, deadline)
+			}
+		}
+		if len(current) == 0 {
+			return nil, 0, nil, NewErrCorruptSnapshot("empty key")
+		}
+		if restoreKey(root, string(current), int(value)) {
+			size++
+		}
+		previous = current
+	}
+
+	expected := sr.crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(sr.r, trailer[:]); err != nil {
+		return nil, 0, nil, NewErrCorruptSnapshot("missing checksum")
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != expected {
+		return nil, 0, nil, NewErrCorruptSnapshot("checksum mismatch")
+	}
+	return root, size, deadlines, nil
+}
@@ -0,0 +1,102 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+func buildSampleSnapshotTrie() *Repository {
+	repo := buildTrieFromTokens(1, "home/", "home/user/", "home/bin/", "home/bin/tar", "root/etc", "ünï/cødé")
+	_ = repo.Inc("home/bin/*")
+	_ = repo.Inc("home/bin/*")
+	_ = repo.Inc("root/*")
+	_ = repo.Inc("root/etc")
+	return repo
+}
+
+func TestRepository_SaveLoad_RoundTrip(t *testing.T) {
+	repo := buildSampleSnapshotTrie()
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.Save(buffer))
+
+	loaded := buildDefaultTrie()
+	assert.NoError(t, loaded.Load(buffer))
+	assert.Equal(t, repo.Size(), loaded.Size())
+	assert.Equal(t, repo.GetMap("*"), loaded.GetMap("*"))
+	for _, pattern := range []string{"*", "home/*", "home/bin/*", "root/*", "root/etc"} {
+		assert.Equal(t, repo.GetValue(pattern), loaded.GetValue(pattern), pattern)
+	}
+	assert.Empty(t, Diff(repo, loaded, "*"))
+}
+
+func TestRepository_SaveLoad_Empty(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, buildDefaultTrie().Save(buffer))
+	loaded := buildTrieFromTokens(1, "abc")
+	assert.NoError(t, loaded.Load(buffer))
+	assert.Equal(t, 0, loaded.Size())
+	assert.Empty(t, loaded.GetMap("*"))
+}
+
+func TestRepository_Save_PrefixCompression(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, buildTrieFromTokens(1, "abcdefgh1", "abcdefgh2").Save(buffer))
+	// magic, version, count, two entries of which the second shares 8 bytes, crc
+	assert.Equal(t, 4+1+1+(1+1+9+1+1)+(1+1+1+1+1)+4, buffer.Len())
+}
+
+func TestRepository_SaveLoad_Deadlines(t *testing.T) {
+	repo, manual := buildClockTrie()
+	assert.NoError(t, repo.InsertWithTTL("session", 1, time.Minute))
+	_ = repo.Insert("user", 2)
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, repo.Save(buffer))
+
+	loaded := buildDefaultTrie().AddClock(manual)
+	assert.NoError(t, loaded.Load(buffer))
+	ttl, ok := loaded.TTL("session")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+	_, ok = loaded.TTL("user")
+	assert.False(t, ok)
+	manual.Advance(time.Minute)
+	assert.False(t, loaded.Contains("session"))
+	assert.True(t, loaded.Contains("user"))
+}
+
+func TestRepository_Load_Version1(t *testing.T) {
+	// a version 1 snapshot of "ab" = 3, without deadlines
+	data := []byte{'M', 'E', 'M', 'R', 1, 1, 0, 2, 'a', 'b', 6}
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(data))
+	repo := buildDefaultTrie()
+	assert.NoError(t, repo.Load(bytes.NewReader(append(data, trailer[:]...))))
+	assert.Equal(t, map[string]int{"ab": 3}, repo.GetMap("*"))
+}
+
+func TestRepository_Load_BadMagic(t *testing.T) {
+	repo := buildTrieFromTokens(1, "abc")
+	assert.Error(t, repo.Load(bytes.NewBufferString("NOPE")))
+	assert.Equal(t, 1, repo.Size())
+}
+
+func TestRepository_Load_Truncated(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	_ = buildSampleSnapshotTrie().Save(buffer)
+	data := buffer.Bytes()
+	for _, size := range []int{5, len(data) / 2, len(data) - 1} {
+		assert.Error(t, buildDefaultTrie().Load(bytes.NewReader(data[:size])))
+	}
+}
+
+func TestRepository_Load_ChecksumMismatch(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	_ = buildSampleSnapshotTrie().Save(buffer)
+	data := buffer.Bytes()
+	data[len(data)-6] ^= 0x01
+	assert.Error(t, buildDefaultTrie().Load(bytes.NewReader(data)))
+}
@@ -0,0 +1,69 @@
+package trie
+
+import "unsafe"
+
+// size and shape of a repository, as reported by Stats
+type Stats struct {
+	// nodes of the radix tree, the root included
+	Nodes int
+	// raw keys, same as Size
+	Keys int
+	// nodes holding a pending selector increment, e.g. "home/*"
+	PendingSelectors int
+	// number of nodes from the root to the deepest key
+	MaxDepth int
+	// average number of nodes from the root to a key
+	AverageDepth float64
+	// number of nodes by number of children
+	Branching map[int]int
+	// estimated heap held by the nodes, their labels and their children
+	EstimatedHeapBytes int
+}
+
+// walks the whole repository and reports its size and shape
+func (t *Repository) Stats() Stats {
+	t.expireDue()
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	stats := Stats{Branching: make(map[int]int)}
+	depthSum := 0
+	dfsStats(t.root, 0, &stats, &depthSum)
+	if stats.Keys > 0 {
+		stats.AverageDepth = float64(depthSum) / float64(stats.Keys)
+	}
+	stats.EstimatedHeapBytes += int(unsafe.Sizeof(*t))
+	return stats
+}
+
+func dfsStats(tn *trieNode, depth int, stats *Stats, depthSum *int) {
+	stats.Nodes++
+	stats.Branching[tn.noOfChildren()]++
+	stats.EstimatedHeapBytes += estimatedNodeSize(tn)
+	if tn.hasSelector {
+		stats.PendingSelectors++
+	}
+	if tn.endOfKey {
+		stats.Keys++
+		*depthSum += depth
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+	for _, child := range tn.sortedChildren() {
+		dfsStats(child, depth+1, stats, depthSum)
+	}
+}
+
+// returns the bytes allocated for a node, its label and its children
+// labels split from the same key share memory, so this is an upper bound
+func estimatedNodeSize(tn *trieNode) int {
+	size := int(unsafe.Sizeof(*tn)) + len(tn.label)
+	size += cap(tn.children.firsts) + cap(tn.children.listed)*int(unsafe.Sizeof((*trieNode)(nil)))
+	if tn.children.table != nil {
+		size += int(unsafe.Sizeof(*tn.children.table))
+	}
+	if tn.decay != nil {
+		size += int(unsafe.Sizeof(*tn.decay))
+	}
+	return size
+}
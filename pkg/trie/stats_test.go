@@ -0,0 +1,42 @@
+package trie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRepository_Stats_Empty(t *testing.T) {
+	stats := New().Stats()
+	assert.Equal(t, 1, stats.Nodes)
+	assert.Equal(t, 0, stats.Keys)
+	assert.Equal(t, 0, stats.MaxDepth)
+	assert.Equal(t, 0.0, stats.AverageDepth)
+	assert.Equal(t, map[int]int{0: 1}, stats.Branching)
+	assert.Greater(t, stats.EstimatedHeapBytes, 0)
+}
+
+func TestRepository_Stats_Shape(t *testing.T) {
+	// root - "ab" - "c"
+	//             \ "d" - "ef"
+	//      \ "x"
+	repo := buildTrieFromTokens(1, "abc", "abd", "abdef", "x")
+	_ = repo.Inc("ab*")
+	_ = repo.Inc("*")
+	stats := repo.Stats()
+	assert.Equal(t, 6, stats.Nodes)
+	assert.Equal(t, 4, stats.Keys)
+	assert.Equal(t, repo.Size(), stats.Keys)
+	assert.Equal(t, 2, stats.PendingSelectors)
+	assert.Equal(t, 3, stats.MaxDepth)
+	assert.Equal(t, float64(2+2+3+1)/4, stats.AverageDepth)
+	assert.Equal(t, map[int]int{0: 3, 1: 1, 2: 2}, stats.Branching)
+}
+
+func TestRepository_Stats_HeapGrows(t *testing.T) {
+	repo := buildTrieFromTokens(1, "abc")
+	before := repo.Stats().EstimatedHeapBytes
+	for _, k := range buildPathDataset(100) {
+		_ = repo.Insert(k, 1)
+	}
+	assert.Greater(t, repo.Stats().EstimatedHeapBytes, before)
+}
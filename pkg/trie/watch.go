@@ -0,0 +1,192 @@
+package trie
+
+import (
+	"context"
+	"github.com/intenvy/memoir/pkg/key"
+	"strings"
+	"sync"
+)
+
+// a change of a single key, as delivered to watchers
+// a pending selector increment changes the key ending with the selector,
+// e.g. an Inc of "home/*" changes "home/*"
+type ChangeEvent struct {
+	Key string
+	// value before the change, zero for a new key
+	Old int
+	New int
+	// operation that made the change, e.g. OperationInc
+	Op string
+}
+
+// what to do with an event when the buffer of a watcher is full
+type SlowConsumerPolicy int
+
+const (
+	// the event is dropped, the writer never waits
+	WatchDrop SlowConsumerPolicy = iota
+	// the writer waits until the watcher has room for the event
+	// or until the context of the watcher is done
+	WatchBlock
+	// the watcher is closed, it misses every later event
+	WatchDisconnect
+)
+
+type WatchConfig struct {
+	// number of events buffered for the watcher
+	Buffer int
+	Policy SlowConsumerPolicy
+}
+
+func DefaultWatchConfig() WatchConfig {
+	return WatchConfig{Buffer: 64, Policy: WatchDrop}
+}
+
+// returns a channel receiving the changes of the keys matching "pattern",
+// i.e. the keys GetMap would return for it, with the default configuration
+// the channel is closed when "ctx" is done
+func (t *Repository) Watch(ctx context.Context, pattern string) <-chan ChangeEvent {
+	return t.WatchWith(ctx, pattern, DefaultWatchConfig())
+}
+
+// same as Watch with the given buffering and slow consumer policy
+// events are delivered once the write lock is released, so events
+// of concurrent writers may be received out of order
+func (t *Repository) WatchWith(ctx context.Context, pattern string, config WatchConfig) <-chan ChangeEvent {
+	entry := key.New(pattern, t.converter, t.validator)
+	w := &watcher{
+		path:     entryPath(entry),
+		selector: entry.IsSelector(),
+		policy:   config.Policy,
+		ctx:      ctx,
+		events:   make(chan ChangeEvent, config.Buffer),
+		stop:     make(chan struct{}),
+	}
+	t.watchers.add(w)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.stop:
+		}
+		t.watchers.remove(w)
+		w.close()
+	}()
+	return w.events
+}
+
+type watcher struct {
+	path     string
+	selector bool
+	policy   SlowConsumerPolicy
+	ctx      context.Context
+	// guards the channel, so it is never sent to once closed
+	mutex  sync.Mutex
+	closed bool
+	events chan ChangeEvent
+	// closed when the watcher disconnects itself
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// a raw watcher also matches the pending increment of its own key,
+// e.g. "home/bin*", as GetValue of the key includes it
+func (w *watcher) matches(event *ChangeEvent) bool {
+	if w.selector {
+		return strings.HasPrefix(event.Key, w.path)
+	}
+	return event.Key == w.path || event.Key == w.path+string(key.SelectorChar)
+}
+
+func (w *watcher) send(event ChangeEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	switch w.policy {
+	case WatchBlock:
+		select {
+		case w.events <- event:
+		case <-w.ctx.Done():
+		}
+	case WatchDisconnect:
+		select {
+		case w.events <- event:
+		default:
+			w.closed = true
+			close(w.events)
+			w.stopOnce.Do(func() { close(w.stop) })
+		}
+	default:
+		select {
+		case w.events <- event:
+		default:
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+}
+
+// watchers of a repository, guarded apart from the repository
+// so events can be published without holding its lock
+type watcherSet struct {
+	mutex    sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+func (ws *watcherSet) add(w *watcher) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.watchers == nil {
+		ws.watchers = make(map[*watcher]struct{})
+	}
+	ws.watchers[w] = struct{}{}
+}
+
+func (ws *watcherSet) remove(w *watcher) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	delete(ws.watchers, w)
+}
+
+// tells if there is any watcher
+func (ws *watcherSet) active() bool {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	return len(ws.watchers) > 0
+}
+
+// delivers "events" to the watchers they match, in order
+// must not be called while holding the lock of the repository
+func (ws *watcherSet) publishAll(events []ChangeEvent) {
+	for _, event := range events {
+		ws.publish(event)
+	}
+}
+
+// delivers "event" to the watchers it matches
+// must not be called while holding the lock of the repository
+func (ws *watcherSet) publish(event ChangeEvent) {
+	ws.mutex.Lock()
+	if len(ws.watchers) == 0 {
+		ws.mutex.Unlock()
+		return
+	}
+	matching := make([]*watcher, 0, len(ws.watchers))
+	for w := range ws.watchers {
+		if w.matches(&event) {
+			matching = append(matching, w)
+		}
+	}
+	ws.mutex.Unlock()
+	for _, w := range matching {
+		w.send(event)
+	}
+}
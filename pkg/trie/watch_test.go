@@ -0,0 +1,223 @@
+package trie
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receives the next event or fails after a second
+func nextEvent(t *testing.T, events <-chan ChangeEvent) (ChangeEvent, bool) {
+	select {
+	case event, ok := <-events:
+		return event, ok
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return ChangeEvent{}, false
+	}
+}
+
+func assertNoEvent(t *testing.T, events <-chan ChangeEvent) {
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestRepository_Watch_RawKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildDefaultTrie()
+	events := repo.Watch(ctx, "Home/A")
+	_ = repo.Insert("home/a", 5)
+	_ = repo.Insert("home/b", 5)
+	_ = repo.Inc("home/a")
+	_ = repo.Inc("home/*")
+
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "home/a", Old: 0, New: 5, Op: OperationInsert}, event)
+	event, _ = nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "home/a", Old: 5, New: 6, Op: OperationInc}, event)
+	assertNoEvent(t, events)
+}
+
+func TestRepository_Watch_Selector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildDefaultTrie()
+	events := repo.Watch(ctx, "home/*")
+	_ = repo.Insert("home/a", 1)
+	_ = repo.Insert("work/a", 1)
+	_ = repo.Inc("home/*")
+	_ = repo.Inc("home/*")
+	// failed operations change nothing
+	_ = repo.Insert("home/a", 1)
+	_ = repo.Inc("home/x")
+
+	expected := []ChangeEvent{
+		{Key: "home/a", New: 1, Op: OperationInsert},
+		{Key: "home/*", Old: 0, New: 1, Op: OperationInc},
+		{Key: "home/*", Old: 1, New: 2, Op: OperationInc},
+	}
+	for _, want := range expected {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, want, event)
+	}
+	assertNoEvent(t, events)
+}
+
+func TestRepository_Watch_ClosedWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := buildDefaultTrie()
+	events := repo.Watch(ctx, "*")
+	cancel()
+	_, ok := nextEvent(t, events)
+	assert.False(t, ok)
+	assert.NoError(t, repo.Insert("a", 1))
+}
+
+func TestRepository_WatchWith_Drop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildTrieFromTokens(0, "a")
+	events := repo.WatchWith(ctx, "a", WatchConfig{Buffer: 1, Policy: WatchDrop})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, repo.Inc("a"))
+	}
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, 1, event.New)
+	assertNoEvent(t, events)
+	assert.NoError(t, repo.Inc("a"))
+	event, _ = nextEvent(t, events)
+	assert.Equal(t, 4, event.New)
+}
+
+func TestRepository_WatchWith_Block(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildTrieFromTokens(0, "a")
+	events := repo.WatchWith(ctx, "a", WatchConfig{Buffer: 0, Policy: WatchBlock})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			_ = repo.Inc("a")
+		}
+	}()
+	for i := 1; i <= 3; i++ {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, i, event.New)
+		// the writer waits for the watcher without holding the lock
+		assert.GreaterOrEqual(t, repo.GetValue("a"), i)
+	}
+	<-done
+}
+
+func TestRepository_WatchWith_BlockReleasedWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := buildTrieFromTokens(0, "a")
+	_ = repo.WatchWith(ctx, "a", WatchConfig{Buffer: 0, Policy: WatchBlock})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = repo.Inc("a")
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writer still blocked")
+	}
+}
+
+func TestRepository_WatchWith_Disconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildTrieFromTokens(0, "a")
+	events := repo.WatchWith(ctx, "a", WatchConfig{Buffer: 1, Policy: WatchDisconnect})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, repo.Inc("a"))
+	}
+	event, ok := nextEvent(t, events)
+	assert.True(t, ok)
+	assert.Equal(t, 1, event.New)
+	_, ok = nextEvent(t, events)
+	assert.False(t, ok)
+}
+
+func TestRepository_Watch_RawKeyOwnSelector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildDefaultTrie()
+	_ = repo.Insert("home/bin", 1)
+	events := repo.Watch(ctx, "home/bin")
+	_ = repo.Inc("home/bin*")
+	_ = repo.Inc("home/*")
+
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, ChangeEvent{Key: "home/bin*", Old: 0, New: 1, Op: OperationInc}, event)
+	assertNoEvent(t, events)
+}
+
+func TestRepository_Watch_Import(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildDefaultTrie()
+	_ = repo.Insert("b", 5)
+	events := repo.Watch(ctx, "*")
+	_, err := repo.ImportCSV(strings.NewReader("a,1\nb,2\nc*,3\n"), CSVOptions{Duplicates: DuplicateSum})
+	assert.NoError(t, err)
+	// skipped entries change nothing
+	_, err = repo.ImportCSV(strings.NewReader("a,7\n"), CSVOptions{Duplicates: DuplicateSkip})
+	assert.NoError(t, err)
+
+	expected := []ChangeEvent{
+		{Key: "a", New: 1, Op: OperationImport},
+		{Key: "b", Old: 5, New: 7, Op: OperationImport},
+		{Key: "c*", New: 3, Op: OperationImport},
+	}
+	for _, want := range expected {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, want, event)
+	}
+	assertNoEvent(t, events)
+}
+
+func TestRepository_Watch_Load(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := buildDefaultTrie()
+	_ = repo.Insert("a", 1)
+	_ = repo.Insert("b", 2)
+	source := buildDefaultTrie()
+	_ = source.Insert("b", 3)
+	_ = source.Insert("c", 4)
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, source.Save(buffer))
+
+	events := repo.Watch(ctx, "*")
+	assert.NoError(t, repo.Load(buffer))
+	expected := []ChangeEvent{
+		{Key: "a", Old: 1, Op: OperationLoad},
+		{Key: "b", Old: 2, New: 3, Op: OperationLoad},
+		{Key: "c", New: 4, Op: OperationLoad},
+	}
+	for _, want := range expected {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, want, event)
+	}
+	assertNoEvent(t, events)
+
+	assert.NoError(t, repo.UnmarshalJSON([]byte(`{"c": 5}`)))
+	for _, want := range []ChangeEvent{
+		{Key: "b", Old: 3, Op: OperationLoad},
+		{Key: "c", Old: 4, New: 5, Op: OperationLoad},
+	} {
+		event, _ := nextEvent(t, events)
+		assert.Equal(t, want, event)
+	}
+}